@@ -0,0 +1,27 @@
+package httpform
+
+import (
+	"reflect"
+)
+
+var (
+	basicAuthType    = reflect.TypeOf(BasicAuth{})
+	basicAuthPtrType = reflect.TypeOf((*BasicAuth)(nil))
+)
+
+// BasicAuth holds HTTP Basic authentication credentials. Decode populates
+// BasicAuth and *BasicAuth fields tagged with form:",basicauth" json:"-"
+// from the Authorization header, failing with 401 if the credentials are
+// missing, unless the field is also marked optional.
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+func setBasicAuthField(fv reflect.Value, auth BasicAuth) {
+	if fv.Type() == basicAuthPtrType {
+		fv.Set(reflect.ValueOf(&auth))
+	} else {
+		fv.Set(reflect.ValueOf(auth))
+	}
+}
@@ -0,0 +1,131 @@
+package httpform
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+)
+
+// ItemError is an element of a batch that failed to decode, see DecodeBatch.
+type ItemError struct {
+	Index int // position in the JSON array
+	Err   error
+}
+
+func (e *ItemError) Error() string {
+	return fmt.Sprintf("item %d: %v", e.Index, e.Err)
+}
+
+func (e *ItemError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeBatch decodes a JSON array body for bulk endpoints, appending each
+// element that decodes successfully to dest, a pointer to a slice of structs
+// or of pointers to structs. Elements are decoded independently, so that bad
+// ones are reported as ItemErrors rather than failing the whole request;
+// an error is only returned when the body isn't a JSON array at all.
+//
+// Elements honor DisallowUnknownFields, LenientJSONBools, readonly, required
+// and UseValidatorTags, but come from the body only: path params, headers and
+// the query string are ignored, and so is JSONEnvelope.
+//
+// Warning: use LimitBody on request before calling DecodeBatch to avoid out-of-memory DoS attacks.
+func (conf *Configuration) DecodeBatch(r *http.Request, dest any) ([]*ItemError, error) {
+	sliceVal := reflect.ValueOf(dest)
+	if sliceVal.Kind() != reflect.Ptr || sliceVal.Elem().Kind() != reflect.Slice {
+		panic(fmt.Errorf("httpform: batch destination must be a pointer to a slice, got %T", dest))
+	}
+	sliceVal = sliceVal.Elem()
+	elemTyp := sliceVal.Type().Elem()
+	structTyp := elemTyp
+	if structTyp.Kind() == reflect.Ptr {
+		structTyp = structTyp.Elem()
+	}
+	if structTyp.Kind() != reflect.Struct {
+		panic(fmt.Errorf("httpform: batch destination must be a pointer to a slice of structs, got %T", dest))
+	}
+	defer r.Body.Close()
+
+	if mtype := determineMIMEType(r); mtype != jsonContentType || !conf.AllowJSON {
+		return nil, &Error{http.StatusUnsupportedMediaType, "batch input must be JSON", nil}
+	}
+
+	var raw json.RawMessage
+	err := conf.newJSONDecoder(r.Body).Decode(&raw)
+	if err == io.EOF && conf.AllowEmptyJSONBody {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, bodyError("JSON input", err)
+	}
+	var items []json.RawMessage
+	if raw[0] != '[' || conf.unmarshalJSON(raw, &items) != nil {
+		return nil, &Error{http.StatusBadRequest, "JSON input", errors.New("expected an array")}
+	}
+
+	sm := conf.lookupStruct(structTyp)
+	disallowUnknown := conf.DisallowUnknownFields && (conf.AllowUnknownFieldsHeader == "" || !parseBoolDefault(r.Header.Get(conf.AllowUnknownFieldsHeader), false))
+
+	var itemErrs []*ItemError
+	for i, data := range items {
+		elemPtr := reflect.New(structTyp)
+		err := conf.decodeBatchItem(elemPtr, sm, data, disallowUnknown)
+		if e, ok := err.(*Error); ok && e.code == http.StatusInternalServerError {
+			return nil, err // a missing or broken Validator, not a bad item
+		} else if err != nil {
+			itemErrs = append(itemErrs, &ItemError{i, err})
+			continue
+		}
+		if elemTyp.Kind() == reflect.Ptr {
+			sliceVal.Set(reflect.Append(sliceVal, elemPtr))
+		} else {
+			sliceVal.Set(reflect.Append(sliceVal, elemPtr.Elem()))
+		}
+	}
+	return itemErrs, nil
+}
+
+func (conf *Configuration) decodeBatchItem(elemPtr reflect.Value, sm *structMeta, data []byte, disallowUnknown bool) error {
+	if conf.LenientJSONBools && len(sm.BoolFields) > 0 {
+		var err error
+		data, err = conf.coerceJSONBools(data, sm.BoolFields)
+		if err != nil {
+			return &Error{http.StatusBadRequest, "JSON input", err}
+		}
+	}
+	decoder := conf.newJSONDecoder(bytes.NewReader(data))
+	if disallowUnknown {
+		decoder.DisallowUnknownFields()
+	}
+	elemVal := elemPtr.Elem()
+	saved := saveFields(elemVal, sm.JSONSkippedFields)
+	err := decoder.Decode(elemPtr.Interface())
+	restoreFields(elemVal, sm.JSONSkippedFields, saved)
+	if err != nil {
+		return jsonInputError(err)
+	}
+
+	if len(sm.RequiredFields) > 0 {
+		var obj map[string]json.RawMessage
+		conf.unmarshalJSON(data, &obj) // the decoder has reported errors already
+		for _, fm := range sm.RequiredFields {
+			if !isJSONValuePresent(obj[fm.name]) {
+				return &Error{http.StatusBadRequest, fmt.Sprintf("missing parameter %s", fm.name), nil}
+			}
+		}
+	}
+	if err := checkConditionalFields(elemVal, sm, &errorCollector{}); err != nil {
+		return err
+	}
+	if conf.UseValidatorTags {
+		if err := conf.validate(elemPtr, sm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
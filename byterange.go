@@ -0,0 +1,127 @@
+package httpform
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	byteRangeType      = reflect.TypeOf(ByteRange{})
+	byteRangePtrType   = reflect.TypeOf((*ByteRange)(nil))
+	byteRangeSliceType = reflect.TypeOf([]ByteRange(nil))
+)
+
+// ByteRange is a single range of a Range header. Both Start and End are
+// inclusive. An open-ended range (bytes=500-) has End == -1. A suffix range
+// (bytes=-500) has a negative Start, i.e. Start == -500, and End == -1.
+//
+// Decode populates ByteRange, *ByteRange and []ByteRange fields tagged
+// with form:",range" json:"-".
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+// Resolve returns the absolute inclusive bounds of the range in a resource
+// of the given size, and false if the range cannot be satisfied.
+func (br ByteRange) Resolve(size int64) (start, end int64, ok bool) {
+	if br.Start < 0 {
+		start, end = size+br.Start, size-1
+		if start < 0 {
+			start = 0
+		}
+	} else {
+		start, end = br.Start, br.End
+		if end < 0 || end >= size {
+			end = size - 1
+		}
+	}
+	return start, end, start <= end && start < size
+}
+
+func (br ByteRange) String() string {
+	switch {
+	case br.Start < 0:
+		return strconv.FormatInt(br.Start, 10)
+	case br.End < 0:
+		return strconv.FormatInt(br.Start, 10) + "-"
+	default:
+		return strconv.FormatInt(br.Start, 10) + "-" + strconv.FormatInt(br.End, 10)
+	}
+}
+
+// ParseRange parses a Range header value like "bytes=0-1023, 2048-".
+// Only the bytes unit is supported.
+func ParseRange(s string) ([]ByteRange, error) {
+	unit, spec, found := strings.Cut(s, "=")
+	if !found || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, fmt.Errorf("invalid range %q: only bytes ranges are supported", s)
+	}
+	var result []ByteRange
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		first, last, found := strings.Cut(item, "-")
+		if !found {
+			return nil, fmt.Errorf("invalid range %q", item)
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		var br ByteRange
+		if first == "" {
+			n, err := parseRangeBound(last)
+			if err != nil || n == 0 {
+				return nil, fmt.Errorf("invalid range %q", item)
+			}
+			br = ByteRange{-n, -1}
+		} else {
+			start, err := parseRangeBound(first)
+			if err != nil {
+				return nil, fmt.Errorf("invalid range %q", item)
+			}
+			end := int64(-1)
+			if last != "" {
+				end, err = parseRangeBound(last)
+				if err != nil || end < start {
+					return nil, fmt.Errorf("invalid range %q", item)
+				}
+			}
+			br = ByteRange{start, end}
+		}
+		result = append(result, br)
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("invalid range %q: no ranges", s)
+	}
+	return result, nil
+}
+
+func parseRangeBound(s string) (int64, error) {
+	if s == "" || s[0] == '+' {
+		return 0, strconv.ErrSyntax
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+func setRangeField(fv reflect.Value, ranges []ByteRange) error {
+	switch fv.Type() {
+	case byteRangeSliceType:
+		fv.Set(reflect.ValueOf(ranges))
+	case byteRangePtrType, byteRangeType:
+		if len(ranges) > 1 {
+			return fmt.Errorf("multiple ranges are not supported")
+		}
+		if fv.Type() == byteRangePtrType {
+			fv.Set(reflect.ValueOf(&ranges[0]))
+		} else {
+			fv.Set(reflect.ValueOf(ranges[0]))
+		}
+	default:
+		panic(fmt.Errorf("httpform: invalid type of range param: %v", fv.Type()))
+	}
+	return nil
+}
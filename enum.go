@@ -0,0 +1,112 @@
+package httpform
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type intEnum struct {
+	names  map[string]int64
+	values map[int64]string
+}
+
+// RegisterIntEnum makes fields of the given integer type parse from and
+// stringify to names, for protobuf-style enums that travel as strings but
+// are stored as integers. If several names map to the same value, the
+// lexicographically first one is used when stringifying. Fields with the
+// enumnumbers modifier also accept the registered numeric values, and fields
+// with caseinsensitive accept names in any case.
+//
+// Registrations only apply to form values, path params, headers and cookies;
+// JSON bodies are decoded by encoding/json and need json.Unmarshaler.
+//
+// Call RegisterIntEnum during initialization, before decoding anything with
+// this Configuration.
+func (conf *Configuration) RegisterIntEnum(typ reflect.Type, names map[string]int64) {
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		break
+	default:
+		panic(fmt.Errorf("httpform: RegisterIntEnum requires an integer type, got %v", typ))
+	}
+
+	enum := &intEnum{
+		names:  make(map[string]int64, len(names)),
+		values: make(map[int64]string, len(names)),
+	}
+	for name, v := range names {
+		enum.names[name] = v
+		if prev, found := enum.values[v]; !found || name < prev {
+			enum.values[v] = name
+		}
+	}
+
+	conf.intEnums = mergeMaps(conf.intEnums, map[reflect.Type]*intEnum{typ: enum})
+	conf.resetStructCache()
+}
+
+// parser accepts names, and with acceptNumbers also the numeric values the
+// names map to, for APIs migrating from numbers to names. Names win if some
+// of them look like numbers.
+func (enum *intEnum) parser(typ reflect.Type, caseInsensitive, acceptNumbers bool) ParserFunc {
+	names := enum.names
+	if caseInsensitive {
+		names = make(map[string]int64, len(enum.names))
+		for name, v := range enum.names {
+			folded := strings.ToLower(name)
+			if prev, found := names[folded]; found && prev != v {
+				panic(fmt.Errorf("httpform: %v values %q and %q differ only in case", typ, name, enum.values[prev]))
+			}
+			names[folded] = v
+		}
+	}
+	return func(s string) (reflect.Value, error) {
+		if s == "" {
+			return reflect.Zero(typ), nil
+		}
+		key := s
+		if caseInsensitive {
+			key = strings.ToLower(s)
+		}
+		v, found := names[key]
+		if !found && acceptNumbers {
+			if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+				_, found = enum.values[n]
+				v = n
+			}
+		}
+		if !found {
+			return reflect.Value{}, fmt.Errorf("invalid value %q, expected one of: %s", s, strings.Join(enum.sortedNames(), ", "))
+		}
+		return reflect.ValueOf(v).Convert(typ), nil
+	}
+}
+
+func (enum *intEnum) stringer() StringerFunc {
+	return func(v reflect.Value) (string, error) {
+		var n int64
+		if v.CanInt() {
+			n = v.Int()
+		} else {
+			n = int64(v.Uint())
+		}
+		name, found := enum.values[n]
+		if !found {
+			return "", fmt.Errorf("no name for value %d", n)
+		}
+		return name, nil
+	}
+}
+
+func (enum *intEnum) sortedNames() []string {
+	names := make([]string, 0, len(enum.names))
+	for name := range enum.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
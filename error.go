@@ -1,7 +1,12 @@
 package httpform
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
 	"strings"
 )
 
@@ -28,6 +33,13 @@ func (e *Error) Error() string {
 	if e.code != 0 {
 		fmt.Fprintf(&buf, "[%d]", e.code)
 	}
+	e.writeText(&buf)
+	return buf.String()
+}
+
+// writeText writes the message and the cause, i.e. Error() without the code,
+// separated from anything already in buf.
+func (e *Error) writeText(buf *strings.Builder) {
 	if e.message != "" {
 		if buf.Len() > 0 {
 			buf.WriteByte(' ')
@@ -42,5 +54,120 @@ func (e *Error) Error() string {
 		}
 		buf.WriteString(e.cause.Error())
 	}
+}
+
+// MultiError holds the errors of all invalid fields when
+// Configuration.CollectAllErrors is set. Decoding returns it wrapped in
+// a 400 *Error; use errors.As to get at it.
+type MultiError struct {
+	errs map[string]error
+}
+
+// FieldErrors maps field names to their errors, which are 400 *Error values
+// themselves, wrapping a FieldError for validator failures.
+func (e *MultiError) FieldErrors() map[string]error {
+	return e.errs
+}
+
+func (e *MultiError) Error() string {
+	names := make([]string, 0, len(e.errs))
+	for name := range e.errs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteString("; ")
+		}
+		if fe, ok := e.errs[name].(*Error); ok {
+			var text strings.Builder
+			fe.writeText(&text)
+			buf.WriteString(text.String())
+		} else {
+			buf.WriteString(e.errs[name].Error())
+		}
+	}
 	return buf.String()
 }
+
+// errorCollector fails fast, or records the first error of each field when
+// collecting all of them.
+type errorCollector struct {
+	collect bool
+	multi   *MultiError
+}
+
+func (c *errorCollector) fail(name string, err *Error) error {
+	if !c.collect {
+		return err
+	}
+	if c.multi == nil {
+		c.multi = &MultiError{make(map[string]error)}
+	}
+	if c.multi.errs[name] == nil {
+		c.multi.errs[name] = err
+	}
+	return nil
+}
+
+// result returns the collected errors, if any.
+func (c *errorCollector) result() error {
+	if c.multi == nil {
+		return nil
+	}
+	return &Error{http.StatusBadRequest, "", c.multi}
+}
+
+// jsonFieldTypeError rephrases json.UnmarshalTypeError in terms of JSON
+// types, naming the offending field.
+type jsonFieldTypeError struct {
+	cause *json.UnmarshalTypeError
+}
+
+func (e *jsonFieldTypeError) Error() string {
+	return fmt.Sprintf("field %q: expected %s, got %s", e.cause.Field, jsonTypeName(e.cause.Type), e.cause.Value)
+}
+
+func (e *jsonFieldTypeError) Unwrap() error {
+	return e.cause
+}
+
+func jsonInputError(err error) *Error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		err = &jsonFieldTypeError{typeErr}
+	}
+	return bodyError("JSON input", err)
+}
+
+// bodyError reports a failure to read or parse the request body, using 413
+// when the body has hit the limit set by LimitBody.
+func bodyError(msg string, err error) *Error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return &Error{http.StatusRequestEntityTooLarge, msg, err}
+	}
+	return &Error{http.StatusBadRequest, msg, err}
+}
+
+func jsonTypeName(typ reflect.Type) string {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	switch typ.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	default:
+		if isIntegerKind(typ.Kind()) || typ.Kind() == reflect.Float32 || typ.Kind() == reflect.Float64 {
+			return "number"
+		}
+		return typ.String()
+	}
+}
@@ -2,10 +2,15 @@ package httpform
 
 import (
 	"bytes"
+	"context"
+	"encoding/gob"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"reflect"
 	"strings"
@@ -20,13 +25,135 @@ type Configuration struct {
 	AllowForm      bool
 	AllowMultipart bool
 
+	// AllowGob decodes application/gob bodies into the destination struct
+	// via encoding/gob, for fast Go-to-Go calls between internal services.
+	// Only enable it for trusted peers: gob is not hardened against
+	// malicious input the way encoding/json is. A gob body counts as
+	// providing every body field: gob does not tell which ones the sender
+	// set, so required and default body fields are not checked or filled.
+	AllowGob bool
+
+	// JSONBodyFallbackParam names a form param that can carry the JSON body,
+	// e.g. a hidden field in an HTML form. When present, it is decoded like
+	// a JSON request body, including into fullbody and rawbody fields.
+	// Values that don't start with { or [ are not considered JSON and are
+	// ignored, so that plain text fields that happen to share the name
+	// stay intact. See also DecodeOptions.DisableJSONBodyFallback.
 	JSONBodyFallbackParam string
 
+	// JSONBodyFallbackHeader, if set, limits JSONBodyFallbackParam to requests
+	// that have this header set to a true value, e.g. X-JSON-Fallback: 1.
+	JSONBodyFallbackHeader string
+
 	MaxMultipartMemory int64
 
+	// RawBodyMemoryLimit is how much of the body io.ReadSeekCloser rawbody
+	// fields keep in memory; larger bodies go to a temp file, which is
+	// removed when the field is closed. Zero means 1 MB. []byte and string
+	// rawbody fields always hold the entire body in memory.
+	RawBodyMemoryLimit int64
+
+	// MaxFileBytes caps the size of an uploaded file read into a []byte field
+	// tagged filebytes=name; larger files fail with 413. Zero means 1 MB.
+	MaxFileBytes int64
+
 	DisallowUnknownFields    bool
 	AllowUnknownFieldsHeader string
 
+	// ZeroFillIndexGaps allows gaps in explicitly indexed slice values like
+	// items.0=a&items.2=c, filling the missing items with zero values.
+	// By default, gaps are rejected.
+	ZeroFillIndexGaps bool
+
+	// ConflictPolicy decides what happens when the query string sets a field
+	// that the JSON body has already set to a different value.
+	ConflictPolicy ConflictPolicy
+
+	// JSONEnvelope, if set, decodes struct fields from the given key of the
+	// JSON body, e.g. {"data": {...}}, instead of the top-level object.
+	// Other keys are ignored, and fullbody fields still get the entire body.
+	JSONEnvelope string
+
+	// AllowEmptyJSONBody makes a zero-length JSON body mean “no fields set”
+	// instead of failing with “JSON input: EOF”.
+	AllowEmptyJSONBody bool
+
+	// TimeLayouts, if set, are the layouts accepted by time.Time form fields,
+	// tried in order until one parses; encoding uses the first one.
+	// By default, time.Time fields use RFC 3339 via its TextUnmarshaler.
+	TimeLayouts []string
+
+	// UseValidatorTags runs Validator over the struct after decoding, mapping
+	// its field errors into ValidationErrors wrapped in a 400 *Error. Without
+	// a Validator, decoding fails with a 500.
+	UseValidatorTags bool
+	Validator        StructValidator
+
+	// LenientJSONBools accepts 0 and 1 for top-level bool fields of a JSON
+	// body, meaning false and true. Other numbers are rejected, and so are
+	// 0 and 1 in strings, arrays and nested objects.
+	LenientJSONBools bool
+
+	// ParseQueryAlways decodes query string params for requests with a body
+	// of any content type, including ones httpform doesn't parse, like
+	// text/plain. Without it, query params are decoded for bodiless requests,
+	// JSON and gob bodies, where they override fields set by the body (subject
+	// to ConflictPolicy), and form bodies, where they are merged with body
+	// params like http.Request.Form does, body values first.
+	ParseQueryAlways bool
+
+	// TrustForwardedHeaders makes remoteip fields take the client IP from
+	// X-Forwarded-For or X-Real-IP when present. Of X-Forwarded-For, the
+	// rightmost address is used: the one appended by the proxy in front of
+	// the app, as the client can forge the ones before it. Only set it behind
+	// exactly one proxy that appends to X-Forwarded-For or sets X-Real-IP.
+	TrustForwardedHeaders bool
+
+	// TrimAllValues strips leading and trailing whitespace from all query and
+	// form body values, as if every form field had the trim modifier.
+	TrimAllValues bool
+
+	// BoolValues adds strings accepted by bool fields, mapped to the value
+	// they mean, e.g. {"si": true, "ja": true, "2": true}. Strings not in it
+	// go through ParseBool, so the built-in tokens like on/off for checkboxes
+	// keep working unless overridden here. Set it before decoding anything,
+	// since parsers are cached per struct type.
+	BoolValues map[string]bool
+
+	// CaseInsensitiveFields matches query and form body params to form fields
+	// regardless of case, e.g. Foo=bar sets a field named foo, for legacy
+	// clients. Exact matches win when both are sent. Structs with form fields
+	// that differ only by case cause a panic. JSON bodies are unaffected.
+	CaseInsensitiveFields bool
+
+	// CollectAllErrors makes decoding go on past invalid and missing fields,
+	// and fail with a 400 *Error wrapping a *MultiError that lists them all,
+	// so that forms can show every problem at once. Errors not tied to
+	// a field, like malformed JSON, still fail right away.
+	CollectAllErrors bool
+
+	// UseServeMuxPathValues makes path fields come from r.PathValue, i.e.
+	// the wildcards of Go 1.22+ http.ServeMux patterns, when Decode gets nil
+	// pathParams. Missing values fail with 400 instead of panicking, since
+	// ServeMux cannot list the wildcards it has matched.
+	UseServeMuxPathValues bool
+
+	// OnUnknownField, if set, is called with each query or form body param
+	// and each top-level JSON key that matches no field, e.g. to track
+	// client drift while still accepting the request. With
+	// DisallowUnknownFields, unknown JSON keys fail the request instead.
+	OnUnknownField func(name string, r *http.Request)
+
+	// Observer, if set, is told how long each decoding phase took.
+	Observer Observer
+
+	// JSONCodec, if set, replaces encoding/json for parsing JSON bodies.
+	JSONCodec JSONCodec
+
+	intEnums    map[reflect.Type]*intEnum
+	customTypes map[reflect.Type]customType
+	units       map[string]float64
+	contextKeys map[string]any
 	structCache sync.Map
 }
 
@@ -43,12 +170,70 @@ var Default = &Configuration{
 }
 
 func (conf *Configuration) Clone() *Configuration {
-	clone := new(Configuration)
-	*clone = *conf
-	conf.structCache = sync.Map{}
+	// registries are copy on write, so sharing them is fine; the struct
+	// cache can't be copied and starts empty
+	clone := &Configuration{
+		intEnums:    conf.intEnums,
+		customTypes: conf.customTypes,
+		units:       conf.units,
+		contextKeys: conf.contextKeys,
+	}
+	copyExportedFields(clone, conf, false)
 	return clone
 }
 
+func copyExportedFields(dst, src *Configuration, skipZero bool) {
+	dv, sv := reflect.ValueOf(dst).Elem(), reflect.ValueOf(src).Elem()
+	for i, n := 0, dv.NumField(); i < n; i++ {
+		if dv.Type().Field(i).IsExported() && !(skipZero && sv.Field(i).IsZero()) {
+			dv.Field(i).Set(sv.Field(i))
+		}
+	}
+}
+
+// resetStructCache drops struct metadata computed before a registration.
+func (conf *Configuration) resetStructCache() {
+	conf.structCache.Range(func(k, _ any) bool {
+		conf.structCache.Delete(k)
+		return true
+	})
+}
+
+// Merge returns a new Configuration with the settings of conf overridden by
+// the non-zero settings of override. Consequently, override can turn bool
+// settings on but not off. Slices and maps like TimeLayouts are replaced as
+// a whole, and enums, types and units registered on override win over
+// the same ones registered on conf.
+func (conf *Configuration) Merge(override *Configuration) *Configuration {
+	merged := conf.Clone()
+	copyExportedFields(merged, override, true)
+	if len(override.units) > 0 {
+		merged.units = mergeMaps(conf.units, override.units)
+	}
+	if len(override.customTypes) > 0 {
+		merged.customTypes = mergeMaps(conf.customTypes, override.customTypes)
+	}
+	if len(override.intEnums) > 0 {
+		merged.intEnums = mergeMaps(conf.intEnums, override.intEnums)
+	}
+	if len(override.contextKeys) > 0 {
+		merged.contextKeys = mergeMaps(conf.contextKeys, override.contextKeys)
+	}
+	return merged
+}
+
+// RegisterContextKey names a context key for use in form:",context=name"
+// tags, which set the field to r.Context().Value(key), e.g. a tenant ID
+// stored by middleware. Fields are left alone when the context has no value,
+// and decoding panics when the value isn't assignable to the field.
+//
+// Call RegisterContextKey during initialization, before decoding anything
+// with this Configuration.
+func (conf *Configuration) RegisterContextKey(name string, key any) {
+	conf.contextKeys = mergeMaps(conf.contextKeys, map[string]any{name: key})
+	conf.resetStructCache()
+}
+
 func (conf *Configuration) Strict() *Configuration {
 	conf = conf.Clone()
 	conf.DisallowUnknownFields = true
@@ -58,172 +243,745 @@ func (conf *Configuration) Strict() *Configuration {
 
 // Decode ...
 //
+// pathParams can be nil, bunrouter.Params, a map[string]string like chi or
+// gorilla/mux produce, url.Values, or a func(key string) (string, bool).
+// For http.ServeMux, pass nil and set UseServeMuxPathValues.
+//
 // Warning: use LimitBody on request before calling Decode to avoid out-of-memory DoS attacks.
 func (conf *Configuration) Decode(r *http.Request, pathParams any, dest any) error {
 	return conf.DecodeVal(r, pathParams, reflect.ValueOf(dest))
 }
 
+// DecodeContext is like Decode, but decodes r as if its context was ctx,
+// e.g. when replaying stored requests in the background: the body is read
+// subject to ctx, and context=name fields come from ctx. Body reads fail
+// with ctx.Err() once ctx is done, but a Read that is already blocked is
+// not interrupted; the error comes from the next one. r.Body is left as is.
+//
+// Warning: use LimitBody on request before calling DecodeContext to avoid out-of-memory DoS attacks.
+func (conf *Configuration) DecodeContext(ctx context.Context, r *http.Request, pathParams any, dest any) error {
+	r2 := r.WithContext(ctx)
+	if ctx.Done() != nil {
+		r2.Body = &contextReader{ctx, r.Body}
+	}
+	err := conf.decode(r2, pathParams, reflect.ValueOf(dest), nil, nil)
+	// like Decode, leave parsed forms on r; net/http then removes the
+	// temp files of r.MultipartForm once the handler returns
+	r.Form, r.PostForm, r.MultipartForm = r2.Form, r2.PostForm, r2.MultipartForm
+	return err
+}
+
+// DecodeWith is like Decode, but lets opts override some of the
+// Configuration settings for this call, and reports what has been decoded.
+// opts can be nil.
+//
+// Warning: use LimitBody on request before calling DecodeWith to avoid out-of-memory DoS attacks.
+func (conf *Configuration) DecodeWith(r *http.Request, pathParams any, dest any, opts *DecodeOptions) (*DecodeResult, error) {
+	res := new(DecodeResult)
+	err := conf.decode(r, pathParams, reflect.ValueOf(dest), opts, res)
+	if err != nil {
+		return nil, err
+	}
+	res.finish(r)
+	return res, nil
+}
+
 // DecodeVal ...
 //
+// Fields marked readonly are response-only and are left untouched.
+//
+// A field tagged form:",rawbody" ([]byte or string) receives the exact bytes
+// of the body, e.g. for verifying webhook signatures, while other fields are
+// still decoded from it as usual. For large bodies, make it io.ReadSeekCloser
+// to spill past RawBodyMemoryLimit to a temp file; the handler must close it.
+// LimitBody still applies.
+//
+// A field tagged form:",body" (io.Reader or io.ReadCloser) receives r.Body
+// as is, for streaming. The body is then neither parsed nor closed, so body
+// params come from the query string only, and the handler owns consuming
+// and closing the reader.
+//
+// Otherwise the body is closed once decoded, except for structs with no
+// query, body or file fields, e.g. a few headers decoded by auth middleware:
+// their decoding never touches the body, so it is left open for the handler
+// that reads it later. This is safe, as net/http closes the body of every
+// server request once the handler returns.
+//
+// Fields of type *multipart.FileHeader or []*multipart.FileHeader receive
+// the uploaded files of a multipart/form-data body under their form name.
+// Like path params, files are required unless marked optional.
+//
+// A []byte field tagged form:"name,filebytes=file" receives the contents of
+// the uploaded file named file, for small files like icons where streaming
+// is overkill. Files over MaxFileBytes are rejected.
+//
+// A header field can name query params to fall back on, tried in order when
+// the header is absent or empty, e.g. form:"X-API-Key,header,query=api_key".
+// The header always wins when both are present.
+//
+// A []string header field tagged form:"Accept,header,headerlist" receives
+// the elements of a comma-separated list header, trimmed and without
+// parameters like ;q=0.9, e.g. ["text/html", "*/*"].
+//
+// A field tagged form:",method" can be a registered enum or another type
+// that parses from a string, in which case unknown methods fail with 405.
+//
+// A field tagged form:",remoteip" (string or netip.Addr) receives the client
+// IP from r.RemoteAddr without the port, see also TrustForwardedHeaders.
+//
+// A field tagged form:",context=name" receives r.Context().Value(key) for
+// the key registered under name via RegisterContextKey.
+//
+// Map fields with string keys collect Rails-style params like meta[color]=red
+// and meta[size]=lg; if a key repeats, the last value wins.
+//
+// destValPtr can also be a *url.Values, which then receives all query and
+// form body params as is, for generic handlers without a typed struct.
+// Other bodies are handled like for structs, except that JSON and gob ones
+// fail with 415, having nowhere to go.
+//
+// Similarly, a *map[string]any receives a JSON object body as decoded by
+// encoding/json (or JSONCodec), and query and form body params as strings,
+// or []string when repeated. Params override JSON keys of the same name,
+// like for structs.
+//
+// A form field tagged form:",queryonly" comes from the query string only,
+// under its JSON name, and is never set from a JSON or form body.
+//
+// A slice field tagged form:",sort" is sorted after decoding, comparing
+// the stringified items when they aren't numbers or strings.
+//
+// A field tagged form:"name,default=value" is set to value when the request
+// doesn't provide it, e.g. form:"page,default=1"; the default must parse as
+// the field's type.
+//
+// A field tagged form:"name,defaultfrom=other" that ends up empty after
+// decoding gets the value of field other, e.g. billing_country defaulting
+// to country. Both fields must have the same type.
+//
+// A field tagged form:"legacy_id,aliasof=id" is copied into field id when
+// id ends up empty, for migrating clients from a deprecated name. Both
+// fields keep their values, so both names round-trip during the transition.
+//
+// Numeric fields tagged form:"name,units" accept values with a unit suffix
+// registered via RegisterUnit, like 10MB or 5km, and store the base quantity.
+//
+// Unexported fields are skipped. To decode a value object that hides its
+// fields, give it an UnmarshalText method acting as its setter (and
+// MarshalText for encoding), or use RegisterType, and make the field holding
+// it exported.
+//
+// Structs and arrays with BinaryMarshaler/BinaryUnmarshaler methods but no
+// text ones are represented as unpadded URL-safe base64 of their binary form.
+//
 // Warning: use LimitBody on request before calling DecodeVal to avoid out-of-memory DoS attacks.
 func (conf *Configuration) DecodeVal(r *http.Request, pathParams any, destValPtr reflect.Value) error {
-	defer r.Body.Close()
+	return conf.decode(r, pathParams, destValPtr, nil, nil)
+}
+
+// ValidateRequest runs the full decoding pipeline, including required fields
+// and validators, into a throwaway instance of struct type t, and returns
+// the error Decode would return. The request is left intact, body included,
+// so that a gateway can check it before forwarding.
+//
+// Warning: use LimitBody on request before calling ValidateRequest to avoid out-of-memory DoS attacks.
+func (conf *Configuration) ValidateRequest(r *http.Request, pathParams any, t reflect.Type) error {
+	if t.Kind() != reflect.Struct {
+		panic(fmt.Errorf("httpform: ValidateRequest needs a struct type, got %v", t))
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		// put back what has been read, the rest is up to the caller
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return bodyError("", err)
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	scratch := r.Clone(r.Context()) // so that parsed forms don't stick to r
+	scratch.Body = io.NopCloser(bytes.NewReader(body))
+	defer func() {
+		// net/http only cleans up r.MultipartForm, not the clone's
+		if scratch.MultipartForm != nil {
+			scratch.MultipartForm.RemoveAll()
+		}
+	}()
+	destValPtr := reflect.New(t)
+	err = conf.decode(scratch, pathParams, destValPtr, nil, nil)
+	closeSpooledRawBody(destValPtr.Elem())
+	return err
+}
+
+// closeSpooledRawBody closes io.ReadSeekCloser rawbody fields of a decoded
+// struct that no handler will see, removing their temp files.
+func closeSpooledRawBody(destVal reflect.Value) {
+	for i, n := 0, destVal.NumField(); i < n; i++ {
+		fv := destVal.Field(i)
+		if fv.Type() == readSeekCloserType && !fv.IsNil() {
+			fv.Interface().(io.ReadSeekCloser).Close()
+		}
+	}
+}
+
+// decode implements DecodeVal and DecodeWith; res is nil unless the caller
+// wants a DecodeResult.
+func (conf *Configuration) decode(r *http.Request, pathParams any, destValPtr reflect.Value, opts *DecodeOptions, res *DecodeResult) (retErr error) {
+	if opts == nil {
+		opts = noOptions
+	}
+	only := opts.OnlySources
+	if only == 0 {
+		only = AllSources
+	}
+	mark := conf.observeStart()
 
 	isBodiless := (r.Method == http.MethodGet || r.Method == http.MethodHead)
 
 	if destValPtr.Kind() != reflect.Ptr {
 		panic(fmt.Errorf("httpform: destination must be a pointer, got %v", destValPtr.Type()))
 	}
+	if destValPtr.Type() == urlValuesPtrType {
+		return conf.decodeValues(r, destValPtr.Interface().(*url.Values), isBodiless, opts)
+	}
+	if destValPtr.Type() == anyMapPtrType {
+		return conf.decodeMap(r, destValPtr.Interface().(*map[string]any), isBodiless, opts)
+	}
 	destVal := destValPtr.Elem()
 	if destVal.Kind() != reflect.Struct {
 		panic(fmt.Errorf("httpform: destination must be a pointer to a struct, got %v", destValPtr.Type()))
 	}
 
-	var cookies map[string]*http.Cookie
+	var cookies map[string][]*http.Cookie
+	ec := &errorCollector{collect: conf.CollectAllErrors}
 
 	sm := conf.lookupStruct(destVal.Type())
+	if !sm.UsesForm {
+		// e.g. a few headers decoded by middleware: leave the body and
+		// the form alone, both for speed and for the handler's sake; the
+		// body stays open for the handler, net/http closes it afterwards
+		only &^= FormSource
+	}
+	if only&FormSource != 0 && !sm.HasBodyStream {
+		defer r.Body.Close()
+	}
 
-	body := func() io.Reader { return r.Body }
-	var rawBody []byte
-	if sm.HasRawBody || (sm.HasBodyForm && sm.HasFullBody) {
-		var err error
-		rawBody, err = io.ReadAll(r.Body)
-		if err != nil {
-			return &Error{400, "", err}
+	var current reflect.Value
+	if opts.Current != nil && len(sm.ImmutableFields) > 0 {
+		current = reflect.ValueOf(opts.Current)
+		if current.Kind() != reflect.Ptr || current.Type() != destValPtr.Type() {
+			panic(fmt.Errorf("httpform: DecodeOptions.Current must be %v, got %T", destValPtr.Type(), opts.Current))
+		}
+		current = current.Elem()
+		// zero them to tell whether the request sets them, and so that
+		// decoding never writes through pointers shared with current
+		for _, fm := range sm.ImmutableFields {
+			f := destVal.Field(fm.fieldIdx)
+			f.Set(reflect.Zero(f.Type()))
 		}
-		r.Body = io.NopCloser(bytes.NewReader(rawBody))
-		body = func() io.Reader { return bytes.NewReader(rawBody) }
 	}
 
+	var rawBody []byte
+	var spool *spooledBody // instead of rawBody for io.ReadSeekCloser fields
 	var fullBody any
-
-	mtype := determineMIMEType(r)
-	if isBodiless {
-		mtype = ""
-	}
-
-	var isBodyParsed bool
-	parseJSONBody := func(body func() io.Reader) error {
-		if sm.HasBodyForm {
-			decoder := json.NewDecoder(body())
-
-			if conf.DisallowUnknownFields && (conf.AllowUnknownFieldsHeader == "" || !parseBoolDefault(r.Header.Get(conf.AllowUnknownFieldsHeader), false)) {
-				decoder.DisallowUnknownFields()
+	if only&FormSource != 0 {
+		body := func() io.Reader { return r.Body }
+		if sm.SpoolsRawBody {
+			limit := conf.RawBodyMemoryLimit
+			if limit == 0 {
+				limit = defaultRawBodyMemoryLimit
 			}
-
-			err := decoder.Decode(destValPtr.Interface())
+			var err error
+			spool, err = spoolBody(r.Body, limit)
 			if err != nil {
-				return &Error{http.StatusBadRequest, "JSON input", err}
+				return bodyError("", err)
 			}
-		}
-		if sm.HasFullBody {
-			decoder := json.NewDecoder(body())
-			err := decoder.Decode(&fullBody)
+			defer func() {
+				if retErr != nil {
+					spool.Close() // the handler won't get to close the field
+				}
+			}()
+			r.Body = io.NopCloser(spool.reader())
+			body = spool.reader
+		} else if sm.HasRawBody || (sm.HasBodyForm && sm.HasFullBody) {
+			var err error
+			rawBody, err = io.ReadAll(r.Body)
 			if err != nil {
-				return &Error{http.StatusBadRequest, "JSON input", err}
+				return bodyError("", err)
 			}
+			r.Body = io.NopCloser(bytes.NewReader(rawBody))
+			body = func() io.Reader { return bytes.NewReader(rawBody) }
 		}
-		isBodyParsed = true
-		return nil
-	}
 
-	switch mtype {
-	case jsonContentType:
-		if !conf.AllowJSON {
-			return &Error{http.StatusUnsupportedMediaType, "JSON input not allowed", nil}
-		}
-		if err := parseJSONBody(body); err != nil {
-			return err
+		mtype := determineMIMEType(r)
+		if isBodiless || sm.HasBodyStream {
+			mtype = ""
 		}
 
-		r.PostForm = make(url.Values) // prevent ParseForm from parsing body
-		if err := r.ParseForm(); err != nil {
-			return &Error{http.StatusBadRequest, "query string", err}
+		envelope := conf.JSONEnvelope
+		if opts.JSONEnvelope != "" {
+			envelope = opts.JSONEnvelope
 		}
-	case "":
-		r.PostForm = make(url.Values) // prevent ParseForm from parsing body
-		if err := r.ParseForm(); err != nil {
-			return &Error{http.StatusBadRequest, "query string", err}
+
+		var isBodyParsed bool
+		var jsonObj map[string]json.RawMessage // top-level keys of the JSON body, if needed
+		var isGobBody bool                     // gob doesn't say which fields it set
+		parseJSONBody := func(body func() io.Reader) error {
+			if sm.HasBodyForm {
+				structBody := body()
+				if envelope != "" {
+					var err error
+					structBody, err = conf.unwrapJSONEnvelope(structBody, envelope)
+					if err != nil {
+						return bodyError("JSON input", err)
+					}
+				}
+				var data []byte
+				lenientBools := conf.LenientJSONBools && len(sm.BoolFields) > 0
+				needKeys := res != nil || len(sm.RequiredFields) > 0 || len(sm.DefaultFields) > 0 || conf.OnUnknownField != nil || conf.ConflictPolicy == RejectConflicts
+				if needKeys || lenientBools {
+					var err error
+					data, err = io.ReadAll(structBody)
+					if err != nil {
+						return bodyError("JSON input", err)
+					}
+					if lenientBools {
+						data, err = conf.coerceJSONBools(data, sm.BoolFields)
+						if err != nil {
+							return &Error{http.StatusBadRequest, "JSON input", err}
+						}
+					}
+					structBody = bytes.NewReader(data)
+				}
+				decoder := conf.newJSONDecoder(structBody)
+
+				disallowUnknown := conf.DisallowUnknownFields && (conf.AllowUnknownFieldsHeader == "" || !parseBoolDefault(r.Header.Get(conf.AllowUnknownFieldsHeader), false))
+				if opts.DisallowUnknownFields != nil {
+					disallowUnknown = *opts.DisallowUnknownFields
+				}
+				if disallowUnknown {
+					decoder.DisallowUnknownFields()
+				}
+
+				saved := saveFields(destVal, sm.JSONSkippedFields)
+				err := decoder.Decode(destValPtr.Interface())
+				restoreFields(destVal, sm.JSONSkippedFields, saved)
+				if err == io.EOF && conf.AllowEmptyJSONBody {
+					err = nil
+				}
+				if err != nil {
+					return jsonInputError(err)
+				}
+				if needKeys {
+					conf.unmarshalJSON(data, &jsonObj) // the decoder has reported errors already
+				}
+				if res != nil {
+					res.noteJSONKeys(sm, jsonObj)
+				}
+				if conf.OnUnknownField != nil {
+					for k := range jsonObj {
+						if !sm.isJSONField(k) {
+							conf.OnUnknownField(k, r)
+						}
+					}
+				}
+			}
+			if sm.HasFullBody {
+				err := conf.newJSONDecoder(body()).Decode(&fullBody)
+				if err == io.EOF && conf.AllowEmptyJSONBody {
+					err = nil
+				}
+				if err != nil {
+					return bodyError("JSON input", err)
+				}
+			}
+			isBodyParsed = true
+			if res != nil {
+				res.ContentType = jsonContentType
+			}
+			return nil
 		}
-	case formContentType:
-		if err := r.ParseForm(); err != nil {
-			return &Error{http.StatusBadRequest, "", err}
+
+		switch mtype {
+		case jsonContentType:
+			if !conf.AllowJSON {
+				return &Error{http.StatusUnsupportedMediaType, "JSON input not allowed", nil}
+			}
+			if err := parseJSONBody(body); err != nil {
+				return err
+			}
+
+			r.PostForm = make(url.Values) // prevent ParseForm from parsing body
+			if err := r.ParseForm(); err != nil {
+				return &Error{http.StatusBadRequest, "query string", err}
+			}
+		case gobContentType:
+			if !conf.AllowGob {
+				return &Error{http.StatusUnsupportedMediaType, "gob input not allowed", nil}
+			}
+			if sm.HasBodyForm {
+				saved := saveFields(destVal, sm.JSONSkippedFields)
+				err := gob.NewDecoder(body()).Decode(destValPtr.Interface())
+				restoreFields(destVal, sm.JSONSkippedFields, saved)
+				if err != nil {
+					return bodyError("gob input", err)
+				}
+			}
+			isBodyParsed = true
+			isGobBody = true
+			if res != nil {
+				res.ContentType = mtype
+			}
+
+			r.PostForm = make(url.Values) // prevent ParseForm from parsing body
+			if err := r.ParseForm(); err != nil {
+				return &Error{http.StatusBadRequest, "query string", err}
+			}
+		default:
+			if err := conf.parseForm(r, mtype, opts); err != nil {
+				return err
+			}
+			if res != nil && (mtype == formContentType || mtype == multipartFormContentType) {
+				res.ContentType = mtype
+			}
 		}
-	case multipartFormContentType:
-		err := r.ParseMultipartForm(conf.MaxMultipartMemory)
-		if err != nil {
-			return &Error{http.StatusBadRequest, "", err}
+
+		form := r.Form
+		if sm.FoldedFields != nil {
+			form = foldFormKeys(form, sm)
 		}
-	}
 
-	for k, vv := range r.Form {
-		for _, v := range vv {
-			err := setVal(destVal, sm, formSrc, k, v)
-			if err != nil {
-				return &Error{http.StatusBadRequest, "", err}
+		mark = conf.observe(r, BodyPhase, mark)
+
+		var bareKeys map[string]bool
+		if sm.HasFlags {
+			bareKeys = bareQueryKeys(r.URL.RawQuery)
+			if sm.FoldedFields != nil {
+				for k := range bareKeys {
+					if fm := sm.FoldedFields[strings.ToLower(k)]; fm != nil {
+						bareKeys[fm.name] = true
+					}
+				}
 			}
 		}
-	}
-	if !isBodyParsed && conf.JSONBodyFallbackParam != "" {
-		bodyStr := r.Form.Get(conf.JSONBodyFallbackParam)
-		if bodyStr != "" {
-			// log.Printf("parsing fallback body:\n===\n%s\n===\n", bodyStr)
-			err := parseJSONBody(func() io.Reader { return strings.NewReader(bodyStr) })
+		var urlQuery url.Values // for queryonly fields when the form has body params
+		if sm.HasQueryOnly && (mtype == formContentType || mtype == multipartFormContentType) {
+			urlQuery = r.URL.Query()
+			if sm.FoldedFields != nil {
+				urlQuery = foldFormKeys(urlQuery, sm)
+			}
+		}
+		inForm := func(fm *fieldMeta) bool {
+			params := form
+			if fm.QueryOnly && urlQuery != nil {
+				params = urlQuery
+			}
+			_, found := params[fm.name]
+			return found
+		}
+		var indexed map[*fieldMeta]map[int]string
+		var mapped map[*fieldMeta]map[string]string
+		for k, vv := range form {
+			if fm := sm.NamedFields[k]; fm != nil && fm.IsJSONOnly {
+				continue // bound by the JSON decoder only, even if the key shows up in the query
+			}
+			if fm := sm.NamedFields[k]; fm != nil && fm.QueryOnly && urlQuery != nil {
+				if vv = urlQuery[k]; len(vv) == 0 {
+					continue
+				}
+			}
+			if fm, key, found := sm.lookupMapEntry(k); found {
+				if mapped == nil {
+					mapped = make(map[*fieldMeta]map[string]string)
+				}
+				if mapped[fm] == nil {
+					mapped[fm] = make(map[string]string)
+				}
+				mapped[fm][key] = vv[len(vv)-1] // last one wins, like for scalar fields
+				res.populated(fm.name)
+				continue
+			}
+			if fm, idx, found := sm.lookupIndexed(k); found {
+				if indexed == nil {
+					indexed = make(map[*fieldMeta]map[int]string)
+				}
+				if indexed[fm] == nil {
+					indexed[fm] = make(map[int]string)
+				}
+				indexed[fm][idx] = vv[len(vv)-1]
+				res.populated(fm.name)
+				if fm.MaxItems > 0 && len(indexed[fm]) > fm.MaxItems {
+					if err := ec.fail(fm.name, &Error{http.StatusBadRequest, "", fmt.Errorf("invalid %s: %w", fm.name, tooManyItems(fm.MaxItems))}); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if fm := sm.NamedFields[k]; fm != nil && fm.Source == formSrc {
+				if fm.IsDecodable() {
+					res.populated(k)
+				}
+			} else if k != conf.JSONBodyFallbackParam && !sm.AlternateParams[k] {
+				res.warn("unknown parameter %q", k)
+				if conf.OnUnknownField != nil {
+					conf.OnUnknownField(k, r)
+				}
+			}
+			if bareKeys[k] && vv[len(vv)-1] == "" {
+				if fm := sm.NamedFields[k]; fm != nil && fm.IsFlag {
+					vv = []string{"true"} // ?active means active=true
+				}
+			}
+			if _, inJSON := jsonObj[k]; (inJSON || isGobBody) && conf.ConflictPolicy == RejectConflicts {
+				if err := checkConflict(destVal, sm, k, vv); err != nil {
+					if err := ec.fail(k, &Error{http.StatusBadRequest, "", err}); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			err := setVals(destVal, sm, formSrc, k, vv)
 			if err != nil {
-				return err
+				if err := ec.fail(k, &Error{http.StatusBadRequest, "", err}); err != nil {
+					return err
+				}
+			}
+		}
+		for _, fm := range sm.OrderedFields {
+			if items := indexed[fm]; items != nil {
+				err := setIndexedItems(destVal, fm, items, conf.ZeroFillIndexGaps)
+				if err != nil {
+					if err := ec.fail(fm.name, &Error{http.StatusBadRequest, "", err}); err != nil {
+						return err
+					}
+				}
+			}
+			if entries := mapped[fm]; entries != nil {
+				err := setMapEntries(destVal, fm, entries)
+				if err != nil {
+					if err := ec.fail(fm.name, &Error{http.StatusBadRequest, "", err}); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		if !isBodyParsed { // a JSON body may have set them
+			for _, fm := range sm.NullableFields {
+				if _, present := form[fm.name]; !present {
+					setNull(destVal, fm)
+				}
+			}
+		}
+		fallbackAllowed := conf.JSONBodyFallbackHeader == "" || parseBoolDefault(r.Header.Get(conf.JSONBodyFallbackHeader), false)
+		if !isBodyParsed && conf.JSONBodyFallbackParam != "" && !opts.DisableJSONBodyFallback && fallbackAllowed {
+			bodyStr := form.Get(conf.JSONBodyFallbackParam)
+			if looksLikeJSON(bodyStr) { // leave plain text fields named like the param alone
+				// log.Printf("parsing fallback body:\n===\n%s\n===\n", bodyStr)
+				err := parseJSONBody(func() io.Reader { return strings.NewReader(bodyStr) })
+				if err != nil {
+					return err
+				}
+				if spool != nil {
+					spool.Close()
+					spool = &spooledBody{data: []byte(bodyStr), size: int64(len(bodyStr))}
+				} else if sm.HasRawBody {
+					rawBody = []byte(bodyStr) // like for a real JSON request
+				}
+			}
+		}
+		for _, fm := range sm.DefaultFields {
+			_, inJSON := jsonObj[fm.name]
+			inJSON = (inJSON || isGobBody) && !fm.QueryOnly
+			if !inForm(fm) && !inJSON && indexed[fm] == nil && mapped[fm] == nil {
+				setDefault(destVal, fm)
+			}
+		}
+		for _, fm := range sm.RequiredFields {
+			inJSON := !fm.QueryOnly && (isGobBody || isJSONValuePresent(jsonObj[fm.name]))
+			if !inForm(fm) && indexed[fm] == nil && mapped[fm] == nil && !inJSON {
+				if err := ec.fail(fm.name, &Error{http.StatusBadRequest, fmt.Sprintf("missing parameter %s", fm.name), nil}); err != nil {
+					return err
+				}
 			}
 		}
 	}
 
 	pp := interpretPathParams(pathParams)
+	if pathParams == nil && conf.UseServeMuxPathValues {
+		pp = serveMuxPathParamsImpl{r}
+	}
+	var query url.Values // parsed lazily for alternate sources
 
-	for _, fm := range sm.NamedFields {
+	for _, fm := range sm.OrderedFields {
+		if !fm.IsDecodable() || only&fm.Source.Mask() == 0 {
+			continue
+		}
 		switch fm.Source {
 		case pathSrc:
 			v := pp.Get(fm.name)
+			if v == "" && fm.HasDefault {
+				setDefault(destVal, fm)
+				continue
+			}
 			if v == "" {
 				if fm.Optional {
 					continue
 				}
-				paramKeys := pp.Keys()
+				paramKeys, listable := pp.Keys()
+				if !listable {
+					// can't tell a misconfigured route from an empty value
+					if err := ec.fail(fm.name, &Error{http.StatusBadRequest, fmt.Sprintf("missing path parameter %s", fm.name), nil}); err != nil {
+						return err
+					}
+					continue
+				}
 				panic(fmt.Errorf("httpform: missing path parameter %q (got %d path params: %s)", fm.name, len(paramKeys), strings.Join(paramKeys, ", ")))
 			}
 			err := setField(destVal, fm, v)
 			if err != nil {
-				return &Error{http.StatusBadRequest, "", err}
+				if err := ec.fail(fm.name, &Error{http.StatusBadRequest, "", err}); err != nil {
+					return err
+				}
+				continue
 			}
+			res.populated(fm.name)
 		case headerSrc:
 			v := r.Header.Get(fm.name)
+			if fm.HeaderList {
+				v = strings.Join(r.Header.Values(fm.name), ",") // repeated lines form one list
+			}
+			for i := 0; v == "" && i < len(fm.Alternates); i++ {
+				if query == nil {
+					query = r.URL.Query()
+				}
+				v = query.Get(fm.Alternates[i].Name)
+			}
+			if v == "" && fm.HasDefault {
+				setDefault(destVal, fm)
+				continue
+			}
 			if v == "" {
+				if fm.Nullable {
+					setNull(destVal, fm)
+					continue
+				}
 				if fm.Optional {
 					continue
 				}
-				return &Error{http.StatusBadRequest, fmt.Sprintf("missing header %s", fm.name), nil}
+				if err := ec.fail(fm.name, &Error{http.StatusBadRequest, fmt.Sprintf("missing header %s", fm.name), nil}); err != nil {
+					return err
+				}
+				continue
 			}
 			err := setField(destVal, fm, v)
 			if err != nil {
-				return &Error{http.StatusBadRequest, "", err}
+				if err := ec.fail(fm.name, &Error{http.StatusBadRequest, "", err}); err != nil {
+					return err
+				}
+				continue
 			}
+			res.populated(fm.name)
 		case cookieSrc:
 			if cookies == nil {
-				cookies = make(map[string]*http.Cookie)
-				for _, cookie := range r.Cookies() {
-					cookies[cookie.Name] = cookie
+				cookies = groupCookies(r)
+			}
+			// Browsers send the most specific cookie first, so like
+			// r.Cookie, scalar fields take the first one; slices get all.
+			cc := cookies[fm.name]
+			if len(cc) == 0 {
+				if fm.HasDefault {
+					setDefault(destVal, fm)
+					continue
 				}
+				if fm.Nullable {
+					setNull(destVal, fm)
+				}
+				continue
 			}
-			c := cookies[fm.name]
-			if c != nil {
-				err := setField(destVal, fm, c.Value)
-				if err != nil {
-					return &Error{http.StatusBadRequest, "", err}
+			var err error
+			if fm.ParseItem != nil {
+				values := make([]string, len(cc))
+				for i, c := range cc {
+					values[i] = c.Value
+				}
+				err = setFieldItems(destVal, fm, values)
+			} else {
+				err = setField(destVal, fm, cc[0].Value)
+			}
+			if err != nil {
+				if err := ec.fail(fm.name, &Error{http.StatusBadRequest, "", err}); err != nil {
+					return err
+				}
+				continue
+			}
+			res.populated(fm.name)
+		case cookieStructSrc:
+			if cookies == nil {
+				cookies = groupCookies(r)
+			}
+			if cc := cookies[fm.name]; len(cc) > 0 {
+				setCookieField(destVal.Field(fm.fieldIdx), cc)
+				res.populated(fm.name)
+			}
+		case fileSrc:
+			var files []*multipart.FileHeader
+			if r.MultipartForm != nil {
+				files = r.MultipartForm.File[fm.name]
+			}
+			if len(files) == 0 {
+				if fm.Optional {
+					continue
+				}
+				if err := ec.fail(fm.name, &Error{http.StatusBadRequest, fmt.Sprintf("missing file %s", fm.name), nil}); err != nil {
+					return err
+				}
+				continue
+			}
+			setFileField(destVal.Field(fm.fieldIdx), files)
+			res.populated(fm.name)
+		case fileBytesSrc:
+			var files []*multipart.FileHeader
+			if r.MultipartForm != nil {
+				files = r.MultipartForm.File[fm.FileKey]
+			}
+			if len(files) == 0 {
+				if fm.Optional {
+					continue
+				}
+				if err := ec.fail(fm.name, &Error{http.StatusBadRequest, fmt.Sprintf("missing file %s", fm.FileKey), nil}); err != nil {
+					return err
+				}
+				continue
+			}
+			maxSize := conf.MaxFileBytes
+			if maxSize <= 0 {
+				maxSize = defaultMaxFileBytes
+			}
+			data, err := readFileBytes(files[0], maxSize)
+			if err != nil {
+				e := &Error{http.StatusBadRequest, fmt.Sprintf("file %s", fm.FileKey), err}
+				if err == errFileTooLarge {
+					e = &Error{http.StatusRequestEntityTooLarge, fmt.Sprintf("file %s is too large", fm.FileKey), nil}
 				}
+				if err := ec.fail(fm.name, e); err != nil {
+					return err
+				}
+				continue
 			}
+			fv := destVal.Field(fm.fieldIdx)
+			fv.Set(reflect.ValueOf(data).Convert(fv.Type()))
+			res.populated(fm.name)
 		default:
 			break
 		}
 	}
 
 	for _, fm := range sm.UnnamedFields {
+		if only&fm.Source.Mask() == 0 {
+			continue
+		}
 		var v any
 		switch fm.Source {
 		case requestSrc:
@@ -235,12 +993,22 @@ func (conf *Configuration) DecodeVal(r *http.Request, pathParams any, destValPtr
 		case headersSrc:
 			v = r.Header
 		case methodSrc:
+			if fm.Parse != nil {
+				mv, err := fm.Parse(r.Method)
+				if err != nil {
+					return &Error{http.StatusMethodNotAllowed, fmt.Sprintf("method %s not allowed", r.Method), nil}
+				}
+				setFieldVal(destVal, fm, mv)
+				continue
+			}
 			v = r.Method
 		case isSaveSrc:
 			v = (r.Method == http.MethodPost || r.Method == http.MethodPut || r.Method == http.MethodPatch)
 		case rawBodySrc:
 			fv := destVal.Field(fm.fieldIdx)
-			if isBytes(fv) {
+			if fv.Type() == readSeekCloserType {
+				fv.Set(reflect.ValueOf(spool.readSeekCloser()))
+			} else if isBytes(fv) {
 				fv.Set(reflect.ValueOf(rawBody).Convert(fv.Type()))
 			} else if isString(fv) {
 				fv.Set(reflect.ValueOf(string(rawBody)).Convert(fv.Type()))
@@ -250,21 +1018,243 @@ func (conf *Configuration) DecodeVal(r *http.Request, pathParams any, destValPtr
 			continue
 		case fullBodySrc:
 			v = fullBody
+		case bodySrc:
+			v = r.Body
+		case rawQuerySrc:
+			v = r.URL.RawQuery
+		case protoSrc:
+			v = r.Proto
+		case dispositionFilenameSrc:
+			filename, err := dispositionFilename(r.Header.Get("Content-Disposition"))
+			if err != nil {
+				return &Error{http.StatusBadRequest, "invalid Content-Disposition header", err}
+			}
+			v = filename
+		case remoteIPSrc:
+			ip := remoteIP(r, conf.TrustForwardedHeaders)
+			fv := destVal.Field(fm.fieldIdx)
+			if fv.Type() == netipAddrType {
+				addr, err := netip.ParseAddr(ip)
+				if err != nil {
+					return &Error{http.StatusBadRequest, "invalid client IP", err}
+				}
+				fv.Set(reflect.ValueOf(addr))
+			} else {
+				fv.SetString(ip)
+			}
+			continue
+		case contextSrc:
+			cv := r.Context().Value(fm.ContextKey)
+			if cv == nil {
+				continue
+			}
+			fv := destVal.Field(fm.fieldIdx)
+			if !reflect.TypeOf(cv).AssignableTo(fv.Type()) {
+				panic(fmt.Errorf("httpform: context value %q is %T, which cannot be assigned to field %v.%s of type %v", fm.ContextName, cv, destVal.Type(), destVal.Type().Field(fm.fieldIdx).Name, fv.Type()))
+			}
+			fv.Set(reflect.ValueOf(cv))
+			continue
+		case rangeSrc:
+			s := r.Header.Get("Range")
+			if s == "" {
+				continue
+			}
+			ranges, err := ParseRange(s)
+			if err == nil {
+				err = setRangeField(destVal.Field(fm.fieldIdx), ranges)
+			}
+			if err != nil {
+				return &Error{http.StatusRequestedRangeNotSatisfiable, "", err}
+			}
+			continue
+		case basicAuthSrc:
+			username, password, found := r.BasicAuth()
+			if !found {
+				if fm.Optional {
+					continue
+				}
+				return &Error{http.StatusUnauthorized, "missing basic auth credentials", nil}
+			}
+			setBasicAuthField(destVal.Field(fm.fieldIdx), BasicAuth{username, password})
+			continue
 		default:
 			continue
 		}
 		setFieldVal(destVal, fm, reflect.ValueOf(v))
 	}
+	applyDefaultsFrom(destVal, sm)
+	if err := sortFields(destVal, sm); err != nil {
+		return &Error{http.StatusBadRequest, "", err}
+	}
+	if current.IsValid() {
+		if err := checkImmutableFields(destVal, current, sm.ImmutableFields); err != nil {
+			return err
+		}
+	}
+	if ec.multi == nil { // collected field errors fail the phase
+		mark = conf.observe(r, FieldsPhase, mark)
+	}
+
+	if only&FormSource != 0 {
+		if err := checkConditionalFields(destVal, sm, ec); err != nil {
+			return err
+		}
+	}
+	if conf.UseValidatorTags {
+		err := conf.validate(destValPtr, sm)
+		var verrs ValidationErrors
+		if err != nil && ec.collect && errors.As(err, &verrs) {
+			for _, fe := range verrs {
+				ec.fail(fe.Field, &Error{http.StatusBadRequest, "", fe})
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+	if err := ec.result(); err != nil {
+		return err
+	}
+	if err := afterDecode(r, destValPtr); err != nil {
+		return err
+	}
+	conf.observe(r, ValidationPhase, mark)
+
+	return nil
+}
+
+// parseForm fills r.Form for a request that isn't JSON or gob, like Decode
+// does for structs: form bodies are parsed and merged with the query string,
+// and other bodies are skipped, with the query string parsed only when
+// ParseQueryAlways is on.
+func (conf *Configuration) parseForm(r *http.Request, mtype string, opts *DecodeOptions) error {
+	switch mtype {
+	case "":
+		r.PostForm = make(url.Values) // prevent ParseForm from parsing body
+		if err := r.ParseForm(); err != nil {
+			return &Error{http.StatusBadRequest, "query string", err}
+		}
+	case formContentType:
+		if err := r.ParseForm(); err != nil {
+			return bodyError("", err)
+		}
+	case multipartFormContentType:
+		maxMemory := conf.MaxMultipartMemory
+		if opts.MaxMultipartMemory > 0 {
+			maxMemory = opts.MaxMultipartMemory
+		}
+		if err := r.ParseMultipartForm(maxMemory); err != nil {
+			return bodyError("", err)
+		}
+	default:
+		// ParseMultipartForm only handles form-data; don't silently
+		// drop the parts of multipart/mixed and the like
+		if strings.HasPrefix(mtype, "multipart/") {
+			return &Error{http.StatusUnsupportedMediaType, fmt.Sprintf("unsupported content type %s", mtype), nil}
+		}
+		if conf.ParseQueryAlways {
+			r.PostForm = make(url.Values) // prevent ParseForm from parsing body
+			if err := r.ParseForm(); err != nil {
+				return &Error{http.StatusBadRequest, "query string", err}
+			}
+		}
+	}
+	return nil
+}
+
+// decodeValues fills dest with query and body params, without a struct.
+func (conf *Configuration) decodeValues(r *http.Request, dest *url.Values, isBodiless bool, opts *DecodeOptions) error {
+	defer r.Body.Close()
+	mtype := determineMIMEType(r)
+	if isBodiless {
+		mtype = ""
+	}
+	if mtype == jsonContentType || mtype == gobContentType {
+		// url.Values has no room for them
+		return &Error{http.StatusUnsupportedMediaType, fmt.Sprintf("unsupported content type %s", mtype), nil}
+	}
+	if err := conf.parseForm(r, mtype, opts); err != nil {
+		return err
+	}
+	if *dest == nil {
+		*dest = make(url.Values, len(r.Form))
+	}
+	for k, vv := range r.Form {
+		(*dest)[k] = append((*dest)[k], vv...)
+	}
+	return nil
+}
 
+// decodeMap fills dest with the JSON body and query or form body params,
+// without a struct.
+func (conf *Configuration) decodeMap(r *http.Request, dest *map[string]any, isBodiless bool, opts *DecodeOptions) error {
+	mtype := determineMIMEType(r)
+	if isBodiless {
+		mtype = ""
+	}
+	var values url.Values
+	if mtype == jsonContentType {
+		defer r.Body.Close()
+		if !conf.AllowJSON {
+			return &Error{http.StatusUnsupportedMediaType, "JSON input not allowed", nil}
+		}
+		if *dest == nil {
+			*dest = make(map[string]any)
+		}
+		err := conf.newJSONDecoder(r.Body).Decode(dest)
+		if err == io.EOF && conf.AllowEmptyJSONBody {
+			err = nil
+		}
+		if err != nil {
+			return jsonInputError(err)
+		}
+		if *dest == nil { // the body was null
+			*dest = make(map[string]any)
+		}
+		values = r.URL.Query()
+	} else if err := conf.decodeValues(r, &values, isBodiless, opts); err != nil {
+		return err
+	}
+
+	if *dest == nil {
+		*dest = make(map[string]any, len(values))
+	}
+	for k, vv := range values {
+		if len(vv) == 1 {
+			(*dest)[k] = vv[0]
+		} else {
+			(*dest)[k] = vv
+		}
+	}
 	return nil
 }
 
-// EncodeToValues is a counterpart to Decode.
+// EncodeToValues is a counterpart to Decode. Fields marked writeonly
+// are request-only and are never encoded.
 func (conf *Configuration) EncodeToValues(source any, values url.Values) {
+	err := conf.encodeToValues(source, values, false)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// EncodeToForm returns an application/x-www-form-urlencoded body with
+// the same values as EncodeToValues, for client code that POSTs forms,
+// except that fields marked omitempty (in either form or json tag) are
+// skipped when empty.
+func (conf *Configuration) EncodeToForm(source any) (string, error) {
+	values := make(url.Values)
+	err := conf.encodeToValues(source, values, true)
+	if err != nil {
+		return "", err
+	}
+	return values.Encode(), nil
+}
+
+func (conf *Configuration) encodeToValues(source any, values url.Values, omitEmpty bool) error {
 	sourceVal := reflect.ValueOf(source)
 	if sourceVal.Kind() == reflect.Ptr {
 		if sourceVal.IsNil() {
-			return
+			return nil
 		}
 		sourceVal = sourceVal.Elem()
 	}
@@ -274,19 +1264,44 @@ func (conf *Configuration) EncodeToValues(source any, values url.Values) {
 
 	sm := conf.lookupStruct(sourceVal.Type())
 
-	for _, fm := range sm.NamedFields {
-		if fm.Source != formSrc {
+	for _, fm := range sm.OrderedFields {
+		if fm.Source != formSrc || !fm.IsEncodable() {
+			continue
+		}
+		if fm.StringifyEntry != nil {
+			if err := encodeMapEntries(sourceVal, fm, values); err != nil {
+				return err
+			}
+			continue
+		}
+		if fm.Stringify == nil {
 			continue
 		}
-		values.Set(fm.name, getString(sourceVal, fm))
+		if omitEmpty && fm.OmitEmpty && isEmptyValue(getVal(sourceVal, fm)) {
+			continue
+		}
+		s, err := stringField(sourceVal, fm)
+		if err != nil {
+			return err
+		}
+		values.Set(fm.name, s)
 	}
+	return nil
 }
 
 func (conf *Configuration) EncodeToPath(source any, path string) string {
+	path, err := conf.encodeToPath(source, path)
+	if err != nil {
+		panic(err)
+	}
+	return path
+}
+
+func (conf *Configuration) encodeToPath(source any, path string) (string, error) {
 	sourceVal := reflect.ValueOf(source)
 	if sourceVal.Kind() == reflect.Ptr {
 		if sourceVal.IsNil() {
-			return path
+			return path, nil
 		}
 		sourceVal = sourceVal.Elem()
 	}
@@ -297,22 +1312,159 @@ func (conf *Configuration) EncodeToPath(source any, path string) string {
 	sm := conf.lookupStruct(sourceVal.Type())
 
 	origPath := path
-	for _, fm := range sm.NamedFields {
+	for _, fm := range sm.OrderedFields {
 		if fm.Source != pathSrc {
 			continue
 		}
-		s := getString(sourceVal, fm)
+		s, err := stringField(sourceVal, fm)
+		if err != nil {
+			return "", err
+		}
 		key := ":" + fm.name
 		newPath := strings.ReplaceAll(path, key, s)
 		if newPath == path {
-			panic(fmt.Errorf("%s is not found in %s", key, origPath))
+			return "", fmt.Errorf("%s is not found in %s", key, origPath)
 		}
 		path = newPath
 	}
 
-	return path
+	return path, nil
 }
 
+// EncodeToHeader is a counterpart to decoding header fields: it sets h
+// from the fields tagged header, skipping empty ones.
+func (conf *Configuration) EncodeToHeader(source any, h http.Header) error {
+	sourceVal, sm := conf.encodingSource(source)
+	if sm == nil {
+		return nil
+	}
+	for _, fm := range sm.OrderedFields {
+		if fm.Source != headerSrc || !fm.IsEncodable() {
+			continue
+		}
+		s, err := stringField(sourceVal, fm)
+		if err != nil {
+			return err
+		}
+		if s != "" {
+			h.Set(fm.name, s)
+		}
+	}
+	return nil
+}
+
+// EncodeToCookies is a counterpart to decoding cookie fields: it returns
+// a cookie for each non-empty field tagged cookie, or for each item of
+// a slice field, for use with http.Request.AddCookie.
+func (conf *Configuration) EncodeToCookies(source any) ([]*http.Cookie, error) {
+	sourceVal, sm := conf.encodingSource(source)
+	if sm == nil {
+		return nil, nil
+	}
+	var cookies []*http.Cookie
+	for _, fm := range sm.OrderedFields {
+		if fm.Source != cookieSrc || !fm.IsEncodable() {
+			continue
+		}
+		fv := getVal(sourceVal, fm)
+		if fm.ParseItem != nil {
+			stringify := conf.pickStringer(fv.Type().Elem(), fieldStringRepresenationOpts{})
+			for i, n := 0, fv.Len(); i < n; i++ {
+				s, err := stringify(fv.Index(i))
+				if err != nil {
+					return nil, fmt.Errorf("failed to encode value of %s: %w", fm.name, err)
+				}
+				cookies = append(cookies, &http.Cookie{Name: fm.name, Value: s})
+			}
+			continue
+		}
+		s, err := stringField(sourceVal, fm)
+		if err != nil {
+			return nil, err
+		}
+		if s != "" {
+			cookies = append(cookies, &http.Cookie{Name: fm.name, Value: s})
+		}
+	}
+	return cookies, nil
+}
+
+// EncodeToRequest builds an outbound request from a struct used for
+// decoding, making the package usable as a typed HTTP client. Path params
+// are substituted into urlTemplate as in EncodeToPath, headers and cookies
+// are set as by EncodeToHeader and EncodeToCookies, and form fields go into
+// the query string for GET and HEAD requests, and into an urlencoded body
+// otherwise, skipping empty omitempty fields like EncodeToForm.
+func (conf *Configuration) EncodeToRequest(source any, method, urlTemplate string) (*http.Request, error) {
+	path, err := conf.encodeToPath(source, urlTemplate)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	values := u.Query()
+	err = conf.encodeToValues(source, values, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.Reader = http.NoBody
+	isBodiless := (method == http.MethodGet || method == http.MethodHead)
+	if isBodiless {
+		u.RawQuery = values.Encode()
+	} else {
+		body = strings.NewReader(values.Encode())
+	}
+	r, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if !isBodiless {
+		r.Header.Set("Content-Type", formContentType)
+	}
+	if err := conf.EncodeToHeader(source, r.Header); err != nil {
+		return nil, err
+	}
+	cookies, err := conf.EncodeToCookies(source)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	return r, nil
+}
+
+// encodingSource returns the struct to encode and its metadata, or a nil
+// *structMeta when source is a nil pointer.
+func (conf *Configuration) encodingSource(source any) (reflect.Value, *structMeta) {
+	sourceVal := reflect.ValueOf(source)
+	if sourceVal.Kind() == reflect.Ptr {
+		if sourceVal.IsNil() {
+			return reflect.Value{}, nil
+		}
+		sourceVal = sourceVal.Elem()
+	}
+	if sourceVal.Kind() != reflect.Struct {
+		panic(fmt.Errorf("httpform: source must be a struct (or a pointer to one), got %T", source))
+	}
+	return sourceVal, conf.lookupStruct(sourceVal.Type())
+}
+
+// ConflictPolicy is a value of Configuration.ConflictPolicy.
+type ConflictPolicy int
+
+const (
+	// IgnoreConflicts lets query string values override the JSON body.
+	IgnoreConflicts ConflictPolicy = iota
+	// RejectConflicts fails with a 400 naming the field when the query string
+	// and the JSON body disagree. Only keys present in the body count, even
+	// with zero values; fields filled in before decoding don't.
+	RejectConflicts
+)
+
 type source int
 
 const (
@@ -321,6 +1473,9 @@ const (
 	formSrc
 	cookieSrc
 	headerSrc
+	cookieStructSrc
+	fileSrc
+	fileBytesSrc
 	requestSrc // sources here and below are unnamed
 	urlSrc
 	queryValuesSrc
@@ -329,9 +1484,17 @@ const (
 	isSaveSrc
 	rawBodySrc
 	fullBodySrc
+	rangeSrc
+	basicAuthSrc
+	bodySrc
+	rawQuerySrc
+	protoSrc
+	dispositionFilenameSrc
+	remoteIPSrc
+	contextSrc
 )
 
-var _sources = []string{"none", "path", "form", "cookie", "header", "request", "url", "query values", "headers", "method", "issave", "rawbody", "fullbody"}
+var _sources = []string{"none", "path", "form", "cookie", "header", "cookiestruct", "file", "filebytes", "request", "url", "query values", "headers", "method", "issave", "rawbody", "fullbody", "range", "basicauth", "body", "rawquery", "proto", "dispositionfilename", "remoteip", "context"}
 
 func (v source) String() string {
 	return _sources[v]
@@ -340,3 +1503,9 @@ func (v source) String() string {
 func (v source) IsNamed() bool {
 	return v < requestSrc
 }
+
+var _sourceMasks = []SourceMask{0, PathSource, FormSource, CookieSource, HeaderSource, CookieSource, FormSource, FormSource, RequestSource, RequestSource, RequestSource, HeaderSource, RequestSource, RequestSource, FormSource, FormSource, HeaderSource, HeaderSource, FormSource, RequestSource, RequestSource, HeaderSource, RequestSource, RequestSource}
+
+func (v source) Mask() SourceMask {
+	return _sourceMasks[v]
+}
@@ -1,12 +1,29 @@
 package httpform
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/gob"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
 	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 func TestDecode_query_string(t *testing.T) {
@@ -18,6 +35,57 @@ func TestDecode_query_string(t *testing.T) {
 	eq(t, in.Foo, "bar")
 }
 
+func TestDecode_anonymous_struct_cached(t *testing.T) {
+	conf := Default.Clone()
+	decode := func(query string) string {
+		var in struct {
+			Foo string `json:"foo"`
+		}
+		r := httptest.NewRequest("GET", "https://example.com/subdir/?"+query, nil)
+		ok(t, conf.Decode(r, nil, &in))
+		return in.Foo
+	}
+	for i := 0; i < 3; i++ {
+		eq(t, decode("foo=bar"), "bar")
+	}
+	var in struct {
+		Foo string `json:"foo"`
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?foo=boz", nil)
+	ok(t, conf.Decode(r, nil, &in))
+	eq(t, in.Foo, "boz")
+
+	var n int
+	conf.structCache.Range(func(k, v any) bool {
+		n++
+		return true
+	})
+	eq(t, n, 1)
+}
+
+func TestConfiguration_struct_cache(t *testing.T) {
+	type input struct {
+		Foo string `json:"foo"`
+	}
+	cached := func(conf *Configuration) int {
+		var n int
+		conf.structCache.Range(func(k, v any) bool {
+			n++
+			return true
+		})
+		return n
+	}
+	conf := &Configuration{AllowJSON: true}
+	var in input
+	ok(t, conf.Decode(httptest.NewRequest("GET", "https://example.com/subdir/?foo=bar", nil), nil, &in))
+	eq(t, cached(conf), 1)
+
+	clone := conf.Clone()
+	eq(t, cached(clone), 0)
+	conf.RegisterUnit("KB", 1024)
+	eq(t, cached(conf), 0)
+}
+
 func TestDecode_query_int(t *testing.T) {
 	var in struct {
 		Foo int `json:"foo"`
@@ -27,6 +95,48 @@ func TestDecode_query_int(t *testing.T) {
 	eq(t, in.Foo, 42)
 }
 
+func TestDecode_query_int_leading_zeros(t *testing.T) {
+	var in struct {
+		ID    int    `json:"id"`
+		Code  uint8  `json:"code"`
+		Count int64  `json:"count"`
+		Refs  []uint `json:"refs"`
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?id=007&code=010&count=-0012&refs=08&refs=0", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.ID, 7)
+	eq(t, in.Code, uint8(10))
+	eq(t, in.Count, int64(-12))
+	deepEqual(t, in.Refs, []uint{8, 0})
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?id=0x10", nil)
+	fails(t, Default.Decode(r, nil, &in), `[400] invalid id: strconv.ParseInt: parsing "0x10": invalid syntax`)
+}
+
+func TestDecode_query_flag(t *testing.T) {
+	type input struct {
+		Active bool `json:"active" form:",flag"`
+	}
+	for _, tt := range []struct {
+		query    string
+		expected bool
+	}{
+		{"active", true},
+		{"active&page=2", true},
+		{"active=", false},
+		{"active=0", false},
+		{"active=1", true},
+		{"", false},
+	} {
+		var in input
+		r := httptest.NewRequest("GET", "https://example.com/subdir/?"+tt.query, nil)
+		ok(t, Default.Decode(r, nil, &in))
+		if in.Active != tt.expected {
+			t.Errorf("** ?%s: got %v, wanted %v", tt.query, in.Active, tt.expected)
+		}
+	}
+}
+
 func TestDecode_urlencoded_string(t *testing.T) {
 	var in struct {
 		Foo string `json:"foo"`
@@ -38,7 +148,6 @@ func TestDecode_urlencoded_string(t *testing.T) {
 }
 
 func TestDecode_urlencoded_array(t *testing.T) {
-	t.Skip("arrays not supported yet")
 	var in struct {
 		Foo []string `json:"foo"`
 	}
@@ -48,148 +157,3066 @@ func TestDecode_urlencoded_array(t *testing.T) {
 	deepEqual(t, in.Foo, []string{"bar", "boz"})
 }
 
-func TestDecode_json_string(t *testing.T) {
+func TestDecode_repeated_keys(t *testing.T) {
 	var in struct {
-		Foo string `json:"foo"`
+		Tags  []string `json:"tags"`
+		IDs   []int    `json:"ids" form:",sep=comma,maxitems=3"`
+		Name  string   `json:"name"`
+		Extra any      `json:"extra" form:",jsononly"`
 	}
-	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{ "foo": "bar" }`))
-	r.Header.Set("Content-Type", "application/json")
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?tags=New%20York&tags=Paris&ids=1&ids=2&name=a&name=b&extra=1", nil)
 	ok(t, Default.Decode(r, nil, &in))
-	eq(t, in.Foo, "bar")
+	deepEqual(t, in.Tags, []string{"New York", "Paris"})
+	deepEqual(t, in.IDs, []int{1, 2})
+	eq(t, in.Name, "b")
+	deepEqual(t, in.Extra, nil)
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?ids=1&ids=2&ids=3&ids=4", nil)
+	fails(t, Default.Decode(r, nil, &in), "[400] invalid ids: more than 3 items")
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?ids=1&ids=x", nil)
+	fails(t, Default.Decode(r, nil, &in), `[400] invalid ids: strconv.ParseInt: parsing "x": invalid syntax`)
 }
 
-func TestDecode_json_invalid_ignored_when_no_form_fields(t *testing.T) {
+func TestDecode_jsononly(t *testing.T) {
+	type filter struct {
+		Field string `json:"field"`
+		Value string `json:"value"`
+	}
 	var in struct {
+		Filters []filter `json:"filters" form:",jsononly"`
+		Label   string   `json:"label" form:",jsononly"`
+		Name    string   `json:"name"`
 	}
-	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`< "foo": "bar" }`))
+	r := httptest.NewRequest("POST", "https://example.com/subdir/?filters=x&filters.0=y&label=q&name=a", strings.NewReader(`{"filters": [{"field": "color", "value": "red"}], "label": "j"}`))
 	r.Header.Set("Content-Type", "application/json")
 	ok(t, Default.Decode(r, nil, &in))
+	deepEqual(t, in.Filters, []filter{{"color", "red"}})
+	eq(t, in.Label, "j")
+	eq(t, in.Name, "a")
+
+	in.Filters, in.Label = nil, ""
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?filters=x&label=q", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	deepEqual(t, in.Filters, []filter(nil))
+	eq(t, in.Label, "")
 }
 
-func TestDecode_header_string(t *testing.T) {
+func TestDecode_multipart_array(t *testing.T) {
 	var in struct {
-		Foo string `form:"X-Foo,header" json:"-"`
+		Foo []string `json:"foo"`
 	}
-	r := httptest.NewRequest("POST", "https://example.com/subdir/", nil)
-	r.Header.Set("X-Foo", "bar")
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	w.WriteField("foo", "bar")
+	w.WriteField("foo", "boz")
+	w.Close()
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", body)
+	r.Header.Set("Content-Type", w.FormDataContentType())
+	ok(t, Default.Decode(r, nil, &in))
+	deepEqual(t, in.Foo, []string{"bar", "boz"})
+}
+
+func TestDecode_indexed_array(t *testing.T) {
+	var in struct {
+		Items []int `json:"items"`
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?items.1=20&items.0=10&items.2=30", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	deepEqual(t, in.Items, []int{10, 20, 30})
+}
+
+func TestDecode_path_params(t *testing.T) {
+	type input struct {
+		Org  string `json:"-" form:"org,path"`
+		ID   int    `json:"-" form:"id,path"`
+		Name string `json:"name"`
+	}
+	for _, pathParams := range []any{
+		map[string]string{"org": "acme", "id": "42"},
+		url.Values{"org": {"acme"}, "id": {"42"}},
+		func(key string) (string, bool) {
+			v, found := map[string]string{"org": "acme", "id": "42"}[key]
+			return v, found
+		},
+	} {
+		var in input
+		r := httptest.NewRequest("GET", "https://example.com/subdir/?name=foo", nil)
+		ok(t, Default.Decode(r, pathParams, &in))
+		deepEqual(t, in, input{"acme", 42, "foo"})
+	}
+
+	var in input
+	r := httptest.NewRequest("GET", "https://example.com/subdir/", nil)
+	fails(t, Default.Decode(r, map[string]string{"org": "acme", "id": "x"}, &in), `[400] invalid id: strconv.ParseInt: parsing "x": invalid syntax`)
+	defer func() {
+		e := recover()
+		if e == nil {
+			t.Fatal("** expected a panic")
+		}
+		eq(t, e.(error).Error(), `httpform: missing path parameter "org" (got 2 path params: id, slug)`)
+	}()
+	Default.Decode(r, map[string]string{"slug": "acme", "id": "42"}, &in)
+}
+
+func TestDecode_servemux_path_values(t *testing.T) {
+	type input struct {
+		Org  string `json:"-" form:"org,path"`
+		ID   int    `json:"-" form:"id,path"`
+		Name string `json:"name"`
+	}
+	conf := Default.Clone()
+	conf.UseServeMuxPathValues = true
+
+	r := httptest.NewRequest("GET", "https://example.com/orgs/acme/items/42?name=foo", nil)
+	setter, found := any(r).(interface{ SetPathValue(name, value string) })
+	if !found {
+		t.Skip("http.Request.SetPathValue needs Go 1.22")
+	}
+	setter.SetPathValue("org", "acme")
+	setter.SetPathValue("id", "42")
+	var in input
+	ok(t, conf.Decode(r, nil, &in))
+	deepEqual(t, in, input{"acme", 42, "foo"})
+
+	r = httptest.NewRequest("GET", "https://example.com/orgs/acme/items/?name=foo", nil)
+	any(r).(interface{ SetPathValue(name, value string) }).SetPathValue("org", "acme")
+	fails(t, conf.Decode(r, nil, &in), "[400] missing path parameter id")
+	fails(t, Default.Decode(r, func(key string) (string, bool) { return "", false }, &in), "[400] missing path parameter org")
+}
+
+func TestDecode_array(t *testing.T) {
+	type input struct {
+		Coords [2]float64 `json:"coords" form:",sep=comma"`
+		Color  [3]uint8   `json:"color"`
+		Pair   *[2]string `json:"pair" form:",sep=comma"`
+	}
+	var in input
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?coords=48.85,2.35&color=255+128+0&pair=a,b", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Coords, [2]float64{48.85, 2.35})
+	eq(t, in.Color, [3]uint8{255, 128, 0})
+	eq(t, *in.Pair, [2]string{"a", "b"})
+
+	values := make(url.Values)
+	Default.EncodeToValues(&in, values)
+	eq(t, values.Encode(), "color=255+128+0&coords=48.85%2C2.35&pair=a%2Cb")
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?coords=48.85", nil)
+	fails(t, Default.Decode(r, nil, &in), "[400] invalid coords: expected 2 items, got 1")
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?color=1+2+300", nil)
+	fails(t, Default.Decode(r, nil, &in), `[400] invalid color: strconv.ParseUint: parsing "300": value out of range`)
+}
+
+func TestDecode_skipped_func_and_chan(t *testing.T) {
+	var in struct {
+		Done     chan struct{} `json:"-"`
+		Callback func()        `json:"-"`
+		Foo      string        `json:"foo"`
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?foo=bar&-=x", nil)
 	ok(t, Default.Decode(r, nil, &in))
 	eq(t, in.Foo, "bar")
+	eq(t, in.Done, nil)
+
+	var bad struct {
+		Callback func() `json:"callback"`
+	}
+	defer func() {
+		e := recover()
+		if e == nil {
+			t.Fatal("** expected a panic")
+		}
+		eq(t, e.(error).Error(), `field struct { Callback func() "json:\"callback\"" }.Callback is a func, which cannot be decoded; use json:"-" to skip it`)
+	}()
+	Default.Decode(r, nil, &bad)
 }
 
-func TestDecode_header_int(t *testing.T) {
+func TestDecode_required(t *testing.T) {
+	type input struct {
+		ID    string `form:"id,path" json:"-"`
+		Auth  string `form:"Authorization,header" json:"-"`
+		Trace string `form:"X-Trace,header,optional" json:"-"`
+		Foo   string `json:"foo" form:",required"`
+		Items []int  `json:"items" form:",required"`
+		Bar   string `json:"bar"`
+	}
+	deepEqual(t, Default.RequiredFields(reflect.TypeOf(&input{})), []string{"id", "Authorization", "foo", "items"})
+
+	var in input
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?foo=&items.0=1", nil)
+	r.Header.Set("Authorization", "x")
+	_, err := Default.DecodeWith(r, nil, &in, &DecodeOptions{OnlySources: FormSource | HeaderSource})
+	ok(t, err)
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?items=1&bar=x", nil)
+	r.Header.Set("Authorization", "x")
+	_, err = Default.DecodeWith(r, nil, &in, &DecodeOptions{OnlySources: FormSource | HeaderSource})
+	fails(t, err, "[400] missing parameter foo")
+
+	for _, tt := range []struct {
+		body, err string
+	}{
+		{`foo=&items=1`, ""},
+		{`foo&items=1`, ""},
+		{`items=1&bar=x`, "[400] missing parameter foo"},
+		{`foo=x`, "[400] missing parameter items"},
+	} {
+		r = httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(tt.body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Authorization", "x")
+		_, err = Default.DecodeWith(r, nil, &in, &DecodeOptions{OnlySources: FormSource | HeaderSource})
+		fails(t, err, tt.err)
+	}
+}
+
+func TestDecode_required_json(t *testing.T) {
 	var in struct {
-		Foo int `form:"X-Foo,header" json:"-"`
+		Foo string `json:"foo" form:",required"`
+		Bar string `json:"bar"`
 	}
-	r := httptest.NewRequest("POST", "https://example.com/subdir/", nil)
-	r.Header.Set("X-Foo", "42")
+	for _, tt := range []struct {
+		url, body, err string
+	}{
+		{"/", `{}`, "[400] missing parameter foo"},
+		{"/", `{"bar": "x"}`, "[400] missing parameter foo"},
+		{"/", `{"foo": null}`, "[400] missing parameter foo"},
+		{"/", `{"foo": ""}`, ""},
+		{"/", `{"foo": "x"}`, ""},
+		{"/?foo=x", `{}`, ""},
+	} {
+		r := httptest.NewRequest("POST", "https://example.com"+tt.url, strings.NewReader(tt.body))
+		r.Header.Set("Content-Type", "application/json")
+		fails(t, Default.Decode(r, nil, &in), tt.err)
+	}
+
+	conf := Default.Clone()
+	conf.AllowEmptyJSONBody = true
+	r := httptest.NewRequest("POST", "https://example.com/", strings.NewReader(``))
+	r.Header.Set("Content-Type", "application/json")
+	fails(t, conf.Decode(r, nil, &in), "[400] missing parameter foo")
+}
+
+func TestDecode_requiredwith(t *testing.T) {
+	type input struct {
+		Country string `json:"country"`
+		State   string `json:"state" form:",requiredwith=country"`
+		Email   string `json:"email"`
+		Phone   string `json:"phone" form:",requiredwithout=email"`
+	}
+	for _, tt := range []struct {
+		query string
+		err   string
+	}{
+		{"email=a@example.com", ""},
+		{"email=a@example.com&country=US&state=CA", ""},
+		{"email=a@example.com&country=US", "[400] missing parameter state, required when country is set"},
+		{"phone=123", ""},
+		{"country=US&state=", "[400] missing parameter state, required when country is set"},
+		{"", "[400] missing parameter phone, required when email is not set"},
+	} {
+		var in input
+		r := httptest.NewRequest("GET", "https://example.com/subdir/?"+tt.query, nil)
+		fails(t, Default.Decode(r, nil, &in), tt.err)
+	}
+}
+
+func TestDecode_hidden(t *testing.T) {
+	var in struct {
+		Token string `json:"token" form:",hidden"`
+		Name  string `json:"name"`
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?token=abc&name=foo", nil)
 	ok(t, Default.Decode(r, nil, &in))
-	eq(t, in.Foo, 42)
+	eq(t, in.Token, "abc")
+	deepEqual(t, Default.HiddenFields(reflect.TypeOf(&in)), []string{"token"})
 }
 
-func TestDecode_header_int_invalid(t *testing.T) {
+func TestDecode_literal_dotted_names(t *testing.T) {
 	var in struct {
-		Foo int `form:"X-Foo,header" json:"-"`
+		UserName string `json:"user.name"`
+		Items    []int  `json:"items"`
+		Legacy   string `json:"items.9"`
 	}
-	r := httptest.NewRequest("POST", "https://example.com/subdir/", nil)
-	r.Header.Set("X-Foo", "bar")
-	fails(t, Default.Decode(r, nil, &in), `[400] invalid X-Foo: strconv.ParseInt: parsing "bar": invalid syntax`)
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?user.name=bob&items.0=10&items.1=20&items.9=x", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.UserName, "bob")
+	deepEqual(t, in.Items, []int{10, 20})
+	eq(t, in.Legacy, "x")
+
+	values := make(url.Values)
+	Default.EncodeToValues(&in, values)
+	eq(t, values.Get("user.name"), "bob")
 }
 
-func TestDecode_header_missing(t *testing.T) {
+func TestDecode_indexed_array_gaps(t *testing.T) {
 	var in struct {
-		Foo string `form:"X-Foo,header" json:"-"`
+		Items []string `json:"items"`
 	}
-	r := httptest.NewRequest("POST", "https://example.com/subdir/", nil)
-	fails(t, Default.Decode(r, nil, &in), "[400] missing header X-Foo")
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?items.0=a&items.2=c", nil)
+	fails(t, Default.Decode(r, nil, &in), "[400] invalid items: missing index 1")
+
+	conf := Default.Clone()
+	conf.ZeroFillIndexGaps = true
+	ok(t, conf.Decode(r, nil, &in))
+	deepEqual(t, in.Items, []string{"a", "", "c"})
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?items.10000=a", nil)
+	fails(t, conf.Decode(r, nil, &in), "[400] invalid items: index 10000 is too large")
 }
 
-func TestDecode_header_missing_optional(t *testing.T) {
+func TestDecode_maxitems(t *testing.T) {
 	var in struct {
-		Foo string `form:"X-Foo,header,optional" json:"-"`
+		IDs []int `json:"ids" form:",sep=comma,maxitems=3"`
 	}
-	r := httptest.NewRequest("POST", "https://example.com/subdir/", nil)
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?ids=1,2,3", nil)
 	ok(t, Default.Decode(r, nil, &in))
+	deepEqual(t, in.IDs, []int{1, 2, 3})
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?ids=1,2,3,4", nil)
+	fails(t, Default.Decode(r, nil, &in), "[400] invalid ids: more than 3 items")
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?ids.0=1&ids.1=2&ids.2=3&ids.3=4", nil)
+	fails(t, Default.Decode(r, nil, &in), "[400] invalid ids: more than 3 items")
+
+	// repeated keys count too, and the limit kicks in before parsing
+	var many struct {
+		IDs  []int    `json:"ids" form:",maxitems=100"`
+		Tags []string `json:"tags"`
+	}
+	query := strings.Repeat("ids=1&tags=a&tags=b&", 100)
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?"+query, nil)
+	ok(t, Default.Decode(r, nil, &many))
+	eq(t, len(many.IDs), 100)
+	eq(t, len(many.Tags), 200)
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?"+query+"ids=x", nil)
+	fails(t, Default.Decode(r, nil, &many), "[400] invalid ids: more than 100 items")
 }
 
-func TestDecode_headers(t *testing.T) {
+func TestDecode_slice_sep(t *testing.T) {
 	var in struct {
-		Foo http.Header `json:"-"`
+		Tags  []string `json:"tags" form:",sep=comma"`
+		Words []string `json:"words"`
 	}
-	r := httptest.NewRequest("POST", "https://example.com/subdir/", nil)
-	r.Header.Set("X-Foo", "bar")
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?tags=a,%20b%20,c&words=a%20b,c%09d", nil)
 	ok(t, Default.Decode(r, nil, &in))
-	eq(t, in.Foo.Get("X-Foo"), "bar")
+	deepEqual(t, in.Tags, []string{"a", "b", "c"})
+	deepEqual(t, in.Words, []string{"a", "b,c", "d"})
+
+	values := make(url.Values)
+	Default.EncodeToValues(&in, values)
+	eq(t, values.Get("tags"), "a,b,c")
+	eq(t, values.Get("words"), "a b,c d")
 }
 
-func TestDecode_raw_simple(t *testing.T) {
+func TestDecode_slice_of_pointers(t *testing.T) {
 	var in struct {
-		Body string `form:",rawbody" json:"-"`
+		Foo []*int `json:"foo" form:",sep=comma"`
 	}
-	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{ "foo": "bar" }`))
-	r.Header.Set("Content-Type", "application/json")
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?foo=1,2", nil)
 	ok(t, Default.Decode(r, nil, &in))
-	eq(t, in.Body, `{ "foo": "bar" }`)
+	eq(t, len(in.Foo), 2)
+	eq(t, *in.Foo[0], 1)
+	eq(t, *in.Foo[1], 2)
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?foo=1,,3", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, len(in.Foo), 3)
+	eq(t, *in.Foo[0], 1)
+	eq(t, in.Foo[1], nil)
+	eq(t, *in.Foo[2], 3)
+
+	values := make(url.Values)
+	Default.EncodeToValues(&in, values)
+	eq(t, values.Get("foo"), "1,,3")
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?foo=", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	deepEqual(t, in.Foo, []*int(nil))
 }
 
-func TestDecode_raw_mixed(t *testing.T) {
+func TestFieldsSep(t *testing.T) {
+	deepEqual(t, fieldsSep(" a  b\tc ", ' ', 0, false), []string{"a", "b", "c"})
+	deepEqual(t, fieldsSep("a, b,,c ,", ',', 0, false), []string{"a", "b", "c"})
+	deepEqual(t, fieldsSep("a, b,,c", ',', 0, true), []string{"a", "b", "", "c"})
+	deepEqual(t, fieldsSep("a;b;c;d;e", ';', 2, false), []string{"a", "b", "c"})
+	deepEqual(t, fieldsSep("", ',', 0, false), []string(nil))
+}
+
+func TestDecode_json_string(t *testing.T) {
 	var in struct {
-		Body string `form:",rawbody" json:"-"`
-		Foo  string `json:"foo"`
+		Foo string `json:"foo"`
 	}
 	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{ "foo": "bar" }`))
 	r.Header.Set("Content-Type", "application/json")
 	ok(t, Default.Decode(r, nil, &in))
-	eq(t, in.Body, `{ "foo": "bar" }`)
 	eq(t, in.Foo, "bar")
 }
 
-func TestDecode_raw_typed(t *testing.T) {
+func TestDecode_json_query_override(t *testing.T) {
 	var in struct {
-		Body json.RawMessage `form:",rawbody" json:"-"`
+		Foo string `json:"foo"`
 	}
-	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{ "foo": "bar" }`))
+	r := httptest.NewRequest("POST", "https://example.com/subdir/?foo=boz", strings.NewReader(`{ "foo": "bar" }`))
 	r.Header.Set("Content-Type", "application/json")
 	ok(t, Default.Decode(r, nil, &in))
-	eq(t, string(in.Body), `{ "foo": "bar" }`)
+	eq(t, in.Foo, "boz")
 }
 
-func TestDecode_raw_invalid(t *testing.T) {
+func TestDecode_json_query_conflict(t *testing.T) {
 	var in struct {
-		Body []byte `form:",rawbody" json:"-"`
+		Foo string `json:"foo"`
+		Bar int    `json:"bar"`
 	}
-	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`<"foo": "bar" }`))
+	conf := Default.Clone()
+	conf.ConflictPolicy = RejectConflicts
+
+	r := httptest.NewRequest("POST", "https://example.com/subdir/?foo=boz", strings.NewReader(`{ "foo": "bar" }`))
 	r.Header.Set("Content-Type", "application/json")
-	ok(t, Default.Decode(r, nil, &in))
-	eq(t, string(in.Body), `<"foo": "bar" }`)
+	fails(t, conf.Decode(r, nil, &in), "[400] conflicting values of foo in query string and body")
+
+	r = httptest.NewRequest("POST", "https://example.com/subdir/?foo=bar&bar=42", strings.NewReader(`{ "foo": "bar" }`))
+	r.Header.Set("Content-Type", "application/json")
+	ok(t, conf.Decode(r, nil, &in))
+	eq(t, in.Bar, 42)
+
+	// values filled in by the caller aren't from the body
+	in.Bar = 1
+	r = httptest.NewRequest("POST", "https://example.com/subdir/?bar=2", strings.NewReader(`{ "foo": "x" }`))
+	r.Header.Set("Content-Type", "application/json")
+	ok(t, conf.Decode(r, nil, &in))
+	eq(t, in.Bar, 2)
+
+	r = httptest.NewRequest("POST", "https://example.com/subdir/?bar=2", strings.NewReader(`{ "bar": 0 }`))
+	r.Header.Set("Content-Type", "application/json")
+	fails(t, conf.Decode(r, nil, &in), "[400] conflicting values of bar in query string and body")
 }
 
-func TestDecode_fullbody_solo(t *testing.T) {
+func TestDecode_lenient_json_bools(t *testing.T) {
+	type input struct {
+		Active  bool   `json:"active"`
+		Deleted *bool  `json:"deleted"`
+		Count   int    `json:"count"`
+		Name    string `json:"name"`
+	}
+	conf := Default.Clone()
+	conf.LenientJSONBools = true
+	decode := func(conf *Configuration, body string) (input, error) {
+		var in input
+		r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		err := conf.Decode(r, nil, &in)
+		return in, err
+	}
+
+	in, err := decode(conf, `{"active": 1, "deleted": 0, "count": 1, "name": "x"}`)
+	ok(t, err)
+	eq(t, in.Active, true)
+	eq(t, *in.Deleted, false)
+	eq(t, in.Count, 1)
+	eq(t, in.Name, "x")
+
+	in, err = decode(conf, `{"active": true, "deleted": null}`)
+	ok(t, err)
+	eq(t, in.Active, true)
+	eq(t, in.Deleted, nil)
+
+	_, err = decode(conf, `{"active": 2}`)
+	fails(t, err, `[400] JSON input: field "active": expected boolean, got number 2`)
+	_, err = decode(conf, `{"active": "1"}`)
+	fails(t, err, `[400] JSON input: field "active": expected boolean, got string`)
+	_, err = decode(Default, `{"active": 1}`)
+	fails(t, err, `[400] JSON input: field "active": expected boolean, got number`)
+}
+
+func TestDecode_parse_query_always(t *testing.T) {
+	type input struct {
+		Foo string `json:"foo"`
+		Bar string `json:"bar"`
+	}
+	multipartBody := &bytes.Buffer{}
+	w := multipart.NewWriter(multipartBody)
+	w.WriteField("foo", "body")
+	w.Close()
+
+	for _, tt := range []struct {
+		method, ctype, body string
+		always, never       input
+	}{
+		{"GET", "", "", input{"", "query"}, input{"", "query"}},
+		{"POST", "", "", input{"", "query"}, input{"", "query"}},
+		{"POST", "application/json", `{"foo": "body"}`, input{"body", "query"}, input{"body", "query"}},
+		{"POST", "application/x-www-form-urlencoded", `foo=body`, input{"body", "query"}, input{"body", "query"}},
+		{"POST", w.FormDataContentType(), multipartBody.String(), input{"body", "query"}, input{"body", "query"}},
+		{"POST", "text/plain", `foo=body`, input{"", "query"}, input{"", ""}},
+	} {
+		for _, always := range []bool{true, false} {
+			conf := Default.Clone()
+			conf.ParseQueryAlways = always
+			r := httptest.NewRequest(tt.method, "https://example.com/subdir/?bar=query", strings.NewReader(tt.body))
+			if tt.ctype != "" {
+				r.Header.Set("Content-Type", tt.ctype)
+			}
+			var in input
+			ok(t, conf.Decode(r, nil, &in))
+			expected := tt.never
+			if always {
+				expected = tt.always
+			}
+			if in != expected {
+				t.Errorf("** %s %s with ParseQueryAlways=%v: got %+v, expected %+v", tt.method, tt.ctype, always, in, expected)
+			}
+		}
+	}
+}
+
+func TestDecode_query_with_body_literal_config(t *testing.T) {
+	conf := &Configuration{AllowJSON: true}
+	r := httptest.NewRequest("POST", "https://example.com/?bar=fromquery", strings.NewReader(`{"foo": "x"}`))
+	r.Header.Set("Content-Type", "application/json")
 	var in struct {
-		Body any `form:",fullbody" json:"-"`
+		Foo string `json:"foo"`
+		Bar string `json:"bar"`
 	}
-	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{ "foo": "bar" }`))
+	ok(t, conf.Decode(r, nil, &in))
+	eq(t, in.Foo, "x")
+	eq(t, in.Bar, "fromquery")
+}
+
+func TestDecode_json_type_mismatch(t *testing.T) {
+	var in struct {
+		Age    int      `json:"age"`
+		Tags   []string `json:"tags"`
+		Nested struct {
+			On bool `json:"on"`
+		} `json:"nested" form:",jsononly"`
+	}
+	conf := Default.Strict()
+	for _, tt := range []struct {
+		body string
+		err  string
+	}{
+		{`{"age": "42"}`, `[400] JSON input: field "age": expected number, got string`},
+		{`{"age": 4.2}`, `[400] JSON input: field "age": expected number, got number 4.2`},
+		{`{"tags": "a"}`, `[400] JSON input: field "tags": expected array, got string`},
+		{`{"nested": {"on": 1}}`, `[400] JSON input: field "nested.on": expected boolean, got number`},
+	} {
+		r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(tt.body))
+		r.Header.Set("Content-Type", "application/json")
+		err := conf.Decode(r, nil, &in)
+		fails(t, err, tt.err)
+		var typeErr *json.UnmarshalTypeError
+		eq(t, errors.As(err, &typeErr), true)
+	}
+}
+
+func TestDecode_json_invalid_ignored_when_no_form_fields(t *testing.T) {
+	var in struct {
+	}
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`< "foo": "bar" }`))
 	r.Header.Set("Content-Type", "application/json")
 	ok(t, Default.Decode(r, nil, &in))
-	deepEqual(t, in.Body, map[string]any{"foo": "bar"})
 }
 
-func TestDecode_fullbody_mixed(t *testing.T) {
+func TestDecode_json_empty(t *testing.T) {
+	var in struct {
+		Foo string `json:"foo"`
+	}
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(``))
+	r.Header.Set("Content-Type", "application/json")
+	fails(t, Default.Decode(r, nil, &in), "[400] JSON input: EOF")
+}
+
+func TestDecode_json_empty_allowed(t *testing.T) {
 	var in struct {
-		Body any    `form:",fullbody" json:"-"`
 		Foo  string `json:"foo"`
+		Body any    `form:",fullbody" json:"-"`
 	}
+	conf := Default.Clone()
+	conf.AllowEmptyJSONBody = true
+	r := httptest.NewRequest("POST", "https://example.com/subdir/?foo=bar", strings.NewReader(``))
+	r.Header.Set("Content-Type", "application/json")
+	ok(t, conf.Decode(r, nil, &in))
+	eq(t, in.Foo, "bar")
+	deepEqual(t, in.Body, nil)
+}
+
+func TestDecodeContext(t *testing.T) {
+	var in struct {
+		Foo string `json:"foo"`
+	}
+	ctx, cancel := context.WithCancel(context.Background())
 	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{ "foo": "bar" }`))
 	r.Header.Set("Content-Type", "application/json")
+	ok(t, Default.DecodeContext(ctx, r, nil, &in))
+	eq(t, in.Foo, "bar")
+
+	cancel()
+	r = httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{ "foo": "boz" }`))
+	r.Header.Set("Content-Type", "application/json")
+	body := r.Body
+	err := Default.DecodeContext(ctx, r, nil, &in)
+	fails(t, err, "[400] JSON input: context canceled")
+	eq(t, errors.Is(err, context.Canceled), true)
+	eq(t, in.Foo, "bar")
+	eq(t, r.Body == body, true)
+}
+
+func TestDecodeContext_context_fields(t *testing.T) {
+	var in struct {
+		Tenant string `json:"-" form:",context=tenant"`
+		Name   string `json:"name"`
+	}
+	conf := Default.Clone()
+	conf.RegisterContextKey("tenant", testContextKey("tenant"))
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), testContextKey("tenant"), "acme"))
+	defer cancel()
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`name=bar`))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ok(t, conf.DecodeContext(ctx, r, nil, &in))
+	eq(t, in.Tenant, "acme")
+	eq(t, in.Name, "bar")
+	eq(t, r.PostForm.Get("name"), "bar")
+}
+
+func TestDecodeWith_disallow_unknown_fields(t *testing.T) {
+	var in struct {
+		Foo string `json:"foo"`
+	}
+	disallow := true
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{ "foo": "bar", "boz": 1 }`))
+	r.Header.Set("Content-Type", "application/json")
+	_, err := Default.DecodeWith(r, nil, &in, &DecodeOptions{DisallowUnknownFields: &disallow})
+	fails(t, err, `[400] JSON input: json: unknown field "boz"`)
+}
+
+func TestDecodeWith_disable_fallback(t *testing.T) {
+	var in struct {
+		Foo string `json:"foo"`
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?_body=%7B%22foo%22%3A%22bar%22%7D", nil)
+	_, err := Default.DecodeWith(r, nil, &in, &DecodeOptions{DisableJSONBodyFallback: true})
+	ok(t, err)
+	eq(t, in.Foo, "")
+	_, err = Default.DecodeWith(r, nil, &in, nil)
+	ok(t, err)
+	eq(t, in.Foo, "bar")
+}
+
+func TestDecode_fallback_plain_text(t *testing.T) {
+	var in struct {
+		Foo  string `json:"foo"`
+		Body string `json:"_body"`
+	}
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`foo=x&_body=Hello+%7Bworld%7D`))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	ok(t, Default.Decode(r, nil, &in))
-	deepEqual(t, in.Body, map[string]any{"foo": "bar"})
+	eq(t, in.Foo, "x")
+	eq(t, in.Body, "Hello {world}")
+
+	var only struct {
+		Foo string `json:"foo"`
+	}
+	r = httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`_body=+%7B%22foo%22%3A%22y%22%7D`))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ok(t, Default.Decode(r, nil, &only))
+	eq(t, only.Foo, "y")
+}
+
+func TestConfiguration_JSONBodyFallbackHeader(t *testing.T) {
+	var in struct {
+		Foo string `json:"foo"`
+	}
+	conf := Default.Clone()
+	conf.JSONBodyFallbackHeader = "X-JSON-Fallback"
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?_body=%7B%22foo%22%3A%22bar%22%7D", nil)
+	ok(t, conf.Decode(r, nil, &in))
+	eq(t, in.Foo, "")
+
+	r.Header.Set("X-JSON-Fallback", "1")
+	ok(t, conf.Decode(r, nil, &in))
 	eq(t, in.Foo, "bar")
 }
 
-func ok(t testing.TB, err error) {
-	if err != nil {
-		t.Helper()
-		t.Fatalf("** %v", err)
+func TestDecodeWith_only_sources(t *testing.T) {
+	var in struct {
+		Auth string `form:"Authorization,header" json:"-"`
+		Foo  string `json:"foo"`
 	}
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{ "foo": "bar" }`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Authorization", "secret")
+
+	_, err := Default.DecodeWith(r, nil, &in, &DecodeOptions{OnlySources: HeaderSource})
+	ok(t, err)
+	eq(t, in.Auth, "secret")
+	eq(t, in.Foo, "")
+
+	in.Auth = ""
+	_, err = Default.DecodeWith(r, nil, &in, &DecodeOptions{OnlySources: FormSource})
+	ok(t, err)
+	eq(t, in.Auth, "")
+	eq(t, in.Foo, "bar")
+}
+
+func TestDecodeWith_result(t *testing.T) {
+	var in struct {
+		Foo   string `json:"foo"`
+		Bar   int    `json:"bar"`
+		Baz   string `json:"baz"`
+		Items []int  `json:"items"`
+		Auth  string `form:"Authorization,header" json:"-"`
+	}
+	r := httptest.NewRequest("POST", "https://example.com/subdir/?bar=42&items.0=1&extra=1", strings.NewReader(`{ "foo": "x", "bar": 1, "boz": true }`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Authorization", "secret")
+	if f := reflect.ValueOf(r).Elem().FieldByName("Pattern"); f.IsValid() {
+		f.SetString("POST /subdir/")
+	}
+	res, err := Default.DecodeWith(r, nil, &in, nil)
+	ok(t, err)
+	eq(t, res.ContentType, "application/json")
+	deepEqual(t, res.Fields, []string{"Authorization", "bar", "foo", "items"})
+	deepEqual(t, res.Warnings, []string{`unknown field "boz" in JSON body`, `unknown parameter "extra"`})
+	if reflect.ValueOf(r).Elem().FieldByName("Pattern").IsValid() {
+		eq(t, res.RoutePattern, "POST /subdir/")
+	}
+
+	r = httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`baz=1`))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Authorization", "secret")
+	res, err = Default.DecodeWith(r, nil, &in, nil)
+	ok(t, err)
+	eq(t, res.ContentType, "application/x-www-form-urlencoded")
+	deepEqual(t, res.Fields, []string{"Authorization", "baz"})
+	deepEqual(t, res.Warnings, []string(nil))
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?_body=%7B%22foo%22%3A%22y%22%7D", nil)
+	r.Header.Set("Authorization", "secret")
+	res, err = Default.DecodeWith(r, nil, &in, nil)
+	ok(t, err)
+	eq(t, res.ContentType, "application/json")
+	deepEqual(t, res.Fields, []string{"Authorization", "foo"})
+}
+
+func TestDecodeResult_Has(t *testing.T) {
+	var in struct {
+		Name  string  `json:"name"`
+		Age   int     `json:"age"`
+		Email *string `json:"email"`
+		Page  int     `json:"page" form:",default=1"`
+		Trace string  `form:"X-Trace,header,optional" json:"-"`
+	}
+	r := httptest.NewRequest("PATCH", "https://example.com/subdir/", strings.NewReader(`{"age": 0, "email": null}`))
+	r.Header.Set("Content-Type", "application/json")
+	res, err := Default.DecodeWith(r, nil, &in, nil)
+	ok(t, err)
+	eq(t, res.Has("age"), true)
+	eq(t, res.Has("email"), true)
+	eq(t, res.Has("name"), false)
+	eq(t, res.Has("page"), false)
+	eq(t, in.Page, 1)
+	eq(t, res.Has("X-Trace"), false)
+
+	r = httptest.NewRequest("PATCH", "https://example.com/subdir/?page=2", strings.NewReader(`name=bob`))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Trace", "t1")
+	res, err = Default.DecodeWith(r, nil, &in, nil)
+	ok(t, err)
+	deepEqual(t, res.Fields, []string{"X-Trace", "name", "page"})
+	eq(t, res.Has("name"), true)
+	eq(t, res.Has("age"), false)
+}
+
+func TestDecodeWith_immutable(t *testing.T) {
+	type account struct {
+		ID    string  `json:"id" form:",immutable"`
+		Owner *string `json:"owner" form:",immutable"`
+		Name  string  `json:"name"`
+	}
+	owner := "alice"
+	current := &account{ID: "a1", Owner: &owner, Name: "Old"}
+	decode := func(body string) (*account, error) {
+		var in account
+		r := httptest.NewRequest("PATCH", "https://example.com/subdir/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		_, err := Default.DecodeWith(r, nil, &in, &DecodeOptions{Current: current})
+		return &in, err
+	}
+
+	in, err := decode(`{ "name": "New" }`)
+	ok(t, err)
+	eq(t, in.ID, "a1")
+	eq(t, *in.Owner, "alice")
+	eq(t, in.Name, "New")
+
+	in, err = decode(`{ "id": "a1", "owner": "alice", "name": "New" }`)
+	ok(t, err)
+	eq(t, in.ID, "a1")
+	eq(t, *in.Owner, "alice")
+
+	_, err = decode(`{ "id": "a2", "name": "New" }`)
+	fails(t, err, "[400] id cannot be changed")
+	_, err = decode(`{ "owner": "bob" }`)
+	fails(t, err, "[400] owner cannot be changed")
+	eq(t, *current.Owner, "alice")
+
+	var in2 account
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?id=a2", nil)
+	_, err = Default.DecodeWith(r, nil, &in2, nil)
+	ok(t, err)
+	eq(t, in2.ID, "a2")
+}
+
+func TestDecode_rawquery(t *testing.T) {
+	var in struct {
+		Foo      string `json:"foo"`
+		RawQuery string `form:",rawquery" json:"-"`
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?z=1&foo=a%20b&a=2", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Foo, "a b")
+	eq(t, in.RawQuery, "z=1&foo=a%20b&a=2")
+}
+
+func TestDecode_query_escapes(t *testing.T) {
+	type input struct {
+		Foo      string            `json:"foo"`
+		Tags     []string          `json:"tags" form:",sep=comma"`
+		Meta     map[string]string `json:"meta"`
+		Active   bool              `json:"active" form:",flag"`
+		RawQuery string            `form:",rawquery" json:"-"`
+	}
+	query := "foo=a%26b%3Dc%2Bd+e%2541&tags=x%26y,z%3D&meta%5Bk%26v%5D=1%2B1&active"
+	var in input
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?"+query, nil)
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Foo, "a&b=c+d e%41")
+	deepEqual(t, in.Tags, []string{"x&y", "z="})
+	deepEqual(t, in.Meta, map[string]string{"k&v": "1+1"})
+	eq(t, in.Active, true)
+	eq(t, in.RawQuery, query)
+
+	in = input{}
+	r = httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader("foo=a%26b%3Dc%2Bd+e%2541&active%3D=x"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Foo, "a&b=c+d e%41")
+	eq(t, in.Active, false) // the key is "active=", not a bare active
+
+	values := make(url.Values)
+	Default.EncodeToValues(&input{Foo: "a&b=c+d e%41", Tags: []string{"x&y", "z="}}, values)
+	eq(t, values.Encode(), "active=false&foo=a%26b%3Dc%2Bd+e%2541&tags=x%26y%2Cz%3D")
+}
+
+func TestDecode_proto(t *testing.T) {
+	var in struct {
+		Proto string `form:",proto" json:"-"`
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/", nil)
+	r.Proto, r.ProtoMajor, r.ProtoMinor = "HTTP/2.0", 2, 0
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Proto, "HTTP/2.0")
+}
+
+func TestDecode_dispositionfilename(t *testing.T) {
+	var in struct {
+		Filename string `form:",dispositionfilename" json:"-"`
+		Data     []byte `form:",rawbody" json:"-"`
+	}
+	for _, tt := range []struct {
+		header, filename, err string
+	}{
+		{`attachment; filename="x.png"`, "x.png", ""},
+		{`attachment; filename*=UTF-8''na%C3%AFve.txt`, "naïve.txt", ""},
+		{`attachment; filename="../../etc/passwd"`, "passwd", ""},
+		{`attachment`, "", ""},
+		{``, "", ""},
+		{`attachment; filename="x`, "", "[400] invalid Content-Disposition header: mime: invalid media parameter"},
+	} {
+		in.Filename = ""
+		r := httptest.NewRequest("PUT", "https://example.com/subdir/", strings.NewReader("data"))
+		r.Header.Set("Content-Type", "image/png")
+		if tt.header != "" {
+			r.Header.Set("Content-Disposition", tt.header)
+		}
+		fails(t, Default.Decode(r, nil, &in), tt.err)
+		eq(t, in.Filename, tt.filename)
+	}
+	eq(t, string(in.Data), "data")
+}
+
+func TestDecode_headers_only_leaves_body(t *testing.T) {
+	var in struct {
+		Auth string `form:"Authorization,header" json:"-"`
+	}
+	body := &closeRecorder{Reader: strings.NewReader(`{ "foo": "bar" }`)}
+	r := httptest.NewRequest("POST", "https://example.com/subdir/?foo=boz", body)
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Authorization", "secret")
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Auth, "secret")
+	eq(t, body.closed, false)
+	deepEqual(t, r.Form, url.Values(nil))
+	raw, err := io.ReadAll(r.Body)
+	ok(t, err)
+	eq(t, string(raw), `{ "foo": "bar" }`)
+
+	var in2 struct {
+		Foo  string `json:"foo"`
+		Auth string `form:"Authorization,header" json:"-"`
+	}
+	body = &closeRecorder{Reader: strings.NewReader(`{ "foo": "bar" }`)}
+	r = httptest.NewRequest("POST", "https://example.com/subdir/", body)
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Authorization", "secret")
+	ok(t, Default.Decode(r, nil, &in2))
+	eq(t, in2.Foo, "bar")
+	eq(t, body.closed, true)
+}
+
+func TestDecode_body_stream(t *testing.T) {
+	var in struct {
+		Foo  string        `json:"foo"`
+		Body io.ReadCloser `form:",body" json:"-"`
+	}
+	body := &closeRecorder{Reader: strings.NewReader(`{ "foo": "bar" }`)}
+	r := httptest.NewRequest("POST", "https://example.com/subdir/?foo=boz", body)
+	r.Header.Set("Content-Type", "application/json")
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Foo, "boz")
+	eq(t, body.closed, false)
+	raw, err := io.ReadAll(in.Body)
+	ok(t, err)
+	eq(t, string(raw), `{ "foo": "bar" }`)
+	ok(t, in.Body.Close())
+	eq(t, body.closed, true)
+}
+
+func TestDecode_validator_tags(t *testing.T) {
+	type input struct {
+		Email string `json:"email_address" validate:"required"`
+		Name  string `json:"name"`
+	}
+	conf := Default.Clone()
+	conf.UseValidatorTags = true
+	conf.Validator = requiredValidator{}
+
+	var in input
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?name=bob", nil)
+	err := conf.Decode(r, nil, &in)
+	fails(t, err, `[400] email_address: failed "required" validation`)
+	var verrs ValidationErrors
+	eq(t, errors.As(err, &verrs), true)
+	deepEqual(t, verrs, ValidationErrors{{Field: "email_address", Tag: "required"}})
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?email_address=bob@example.com", nil)
+	ok(t, conf.Decode(r, nil, &in))
+	ok(t, Default.Decode(httptest.NewRequest("GET", "https://example.com/subdir/", nil), nil, &input{}))
+
+	conf.Validator = brokenValidator{}
+	fails(t, conf.Decode(r, nil, &in), `[500] validator: validator: unsupported type *httpform.input`)
+
+	conf.Validator = nil
+	fails(t, conf.Decode(r, nil, &in), `[500] UseValidatorTags requires Validator`)
+
+	conf = Default.Clone()
+	conf.Validator = requiredValidator{}
+	ok(t, conf.Decode(httptest.NewRequest("GET", "https://example.com/subdir/", nil), nil, &input{}))
+}
+
+// brokenValidator fails like go-playground/validator's InvalidValidationError.
+type brokenValidator struct{}
+
+func (brokenValidator) Struct(s any) error {
+	return fmt.Errorf("validator: unsupported type %T", s)
+}
+
+// requiredValidator mimics go-playground/validator, which returns a slice of
+// field errors and supports validate:"required".
+type requiredValidator struct{}
+
+type fakeFieldErrors []fakeFieldError
+
+func (fakeFieldErrors) Error() string { return "validation failed" }
+
+type fakeFieldError struct{ field, tag string }
+
+func (e fakeFieldError) Field() string       { return e.field }
+func (e fakeFieldError) StructField() string { return e.field }
+func (e fakeFieldError) Tag() string         { return e.tag }
+
+func (requiredValidator) Struct(s any) error {
+	v := reflect.ValueOf(s).Elem()
+	var errs fakeFieldErrors
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Tag.Get("validate") == "required" && v.Field(i).IsZero() {
+			errs = append(errs, fakeFieldError{v.Type().Field(i).Name, "required"})
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestDecode_json_envelope(t *testing.T) {
+	var in struct {
+		Foo  string `json:"foo"`
+		Body any    `form:",fullbody" json:"-"`
+	}
+	conf := Default.Clone()
+	conf.JSONEnvelope = "data"
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{ "data": { "foo": "bar" }, "meta": 1 }`))
+	r.Header.Set("Content-Type", "application/json")
+	ok(t, conf.Decode(r, nil, &in))
+	eq(t, in.Foo, "bar")
+	deepEqual(t, in.Body, map[string]any{"data": map[string]any{"foo": "bar"}, "meta": 1.0})
+
+	r = httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{ "foo": "bar" }`))
+	r.Header.Set("Content-Type", "application/json")
+	fails(t, conf.Decode(r, nil, &in), `[400] JSON input: missing "data" envelope`)
+}
+
+func TestDecodeWith_json_envelope(t *testing.T) {
+	var in struct {
+		Foo string `json:"foo"`
+	}
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{ "payload": { "foo": "bar" } }`))
+	r.Header.Set("Content-Type", "application/json")
+	_, err := Default.DecodeWith(r, nil, &in, &DecodeOptions{JSONEnvelope: "payload"})
+	ok(t, err)
+	eq(t, in.Foo, "bar")
+}
+
+func TestDecode_header_string(t *testing.T) {
+	var in struct {
+		Foo string `form:"X-Foo,header" json:"-"`
+	}
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", nil)
+	r.Header.Set("X-Foo", "bar")
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Foo, "bar")
+}
+
+func TestDecode_header_int(t *testing.T) {
+	var in struct {
+		Foo int `form:"X-Foo,header" json:"-"`
+	}
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", nil)
+	r.Header.Set("X-Foo", "42")
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Foo, 42)
+}
+
+func TestDecode_header_int_invalid(t *testing.T) {
+	var in struct {
+		Foo int `form:"X-Foo,header" json:"-"`
+	}
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", nil)
+	r.Header.Set("X-Foo", "bar")
+	fails(t, Default.Decode(r, nil, &in), `[400] invalid X-Foo: strconv.ParseInt: parsing "bar": invalid syntax`)
+}
+
+func TestDecode_header_missing(t *testing.T) {
+	var in struct {
+		Foo string `form:"X-Foo,header" json:"-"`
+	}
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", nil)
+	fails(t, Default.Decode(r, nil, &in), "[400] missing header X-Foo")
+}
+
+func TestDecode_header_missing_optional(t *testing.T) {
+	var in struct {
+		Foo string `form:"X-Foo,header,optional" json:"-"`
+	}
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", nil)
+	ok(t, Default.Decode(r, nil, &in))
+}
+
+func TestDecode_headers(t *testing.T) {
+	var in struct {
+		Foo http.Header `json:"-"`
+	}
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", nil)
+	r.Header.Set("X-Foo", "bar")
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Foo.Get("X-Foo"), "bar")
+}
+
+func TestDecode_cookie_duplicate_first_wins(t *testing.T) {
+	var in struct {
+		SID string `form:"sid,cookie" json:"-"`
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/", nil)
+	r.Header.Add("Cookie", "sid=specific; other=1; sid=general")
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.SID, "specific")
+}
+
+func TestDecode_cookie_duplicate_slice(t *testing.T) {
+	var in struct {
+		SIDs []string `form:"sid,cookie" json:"-"`
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/", nil)
+	r.Header.Add("Cookie", "sid=specific; other=1; sid=general")
+	ok(t, Default.Decode(r, nil, &in))
+	deepEqual(t, in.SIDs, []string{"specific", "general"})
+}
+
+func TestDecode_cookiestruct(t *testing.T) {
+	var in struct {
+		Session *http.Cookie   `form:"session,cookiestruct" json:"-"`
+		All     []*http.Cookie `form:"sid,cookiestruct" json:"-"`
+		Missing *http.Cookie   `form:"missing,cookiestruct" json:"-"`
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/", nil)
+	r.Header.Add("Cookie", "session=abc; sid=1; sid=2")
+	ok(t, Default.Decode(r, nil, &in))
+	deepEqual(t, in.Session, &http.Cookie{Name: "session", Value: "abc"})
+	eq(t, len(in.All), 2)
+	eq(t, in.All[1].Value, "2")
+	eq(t, in.Missing, nil)
+}
+
+func TestDecode_basicauth(t *testing.T) {
+	var in struct {
+		Auth BasicAuth `form:",basicauth" json:"-"`
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/", nil)
+	r.SetBasicAuth("foo", "bar")
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Auth, BasicAuth{"foo", "bar"})
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/", nil)
+	err := Default.Decode(r, nil, &in)
+	fails(t, err, "[401] missing basic auth credentials")
+}
+
+func TestDecode_basicauth_optional(t *testing.T) {
+	var in struct {
+		Auth *BasicAuth `form:",basicauth,optional" json:"-"`
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Auth, nil)
+}
+
+func TestDecode_raw_simple(t *testing.T) {
+	var in struct {
+		Body string `form:",rawbody" json:"-"`
+	}
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{ "foo": "bar" }`))
+	r.Header.Set("Content-Type", "application/json")
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Body, `{ "foo": "bar" }`)
+}
+
+func TestDecode_raw_mixed(t *testing.T) {
+	var in struct {
+		Body string `form:",rawbody" json:"-"`
+		Foo  string `json:"foo"`
+	}
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{ "foo": "bar" }`))
+	r.Header.Set("Content-Type", "application/json")
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Body, `{ "foo": "bar" }`)
+	eq(t, in.Foo, "bar")
+}
+
+func TestDecode_raw_exact_bytes(t *testing.T) {
+	var in struct {
+		Raw  []byte `form:",rawbody" json:"-"`
+		Full any    `form:",fullbody" json:"-"`
+		Foo  string `json:"foo"`
+		Bar  int    `json:"bar"`
+	}
+	body := "\r\n\t{\"bar\" :1,\n  \"foo\":\"caf\\u00e9\" ,\"x\":[ ]}  \n"
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, string(in.Raw), body)
+	eq(t, in.Foo, "café")
+	eq(t, in.Bar, 1)
+	deepEqual(t, in.Full, map[string]any{"foo": "café", "bar": 1.0, "x": []any{}})
+
+	body = "foo=a+b&bar=2&foo=%63"
+	r = httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, string(in.Raw), body)
+	eq(t, in.Bar, 2)
+}
+
+func TestDecode_raw_typed(t *testing.T) {
+	var in struct {
+		Body json.RawMessage `form:",rawbody" json:"-"`
+	}
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{ "foo": "bar" }`))
+	r.Header.Set("Content-Type", "application/json")
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, string(in.Body), `{ "foo": "bar" }`)
+}
+
+func TestDecode_raw_spooled(t *testing.T) {
+	type input struct {
+		Body io.ReadSeekCloser `form:",rawbody" json:"-"`
+		Foo  string            `json:"foo"`
+	}
+	conf := Default.Clone()
+	conf.RawBodyMemoryLimit = 16
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	for _, body := range []string{`{"foo": "bar"}`, `{"foo": "` + strings.Repeat("x", 100) + `"}`} {
+		var in input
+		r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		ok(t, conf.Decode(r, nil, &in))
+		eq(t, in.Foo, body[9:len(body)-2])
+
+		file := in.Body.(*spooledBodyReader).body.file
+		eq(t, file != nil, len(body) > 16)
+		data, err := io.ReadAll(in.Body)
+		ok(t, err)
+		eq(t, string(data), body)
+		_, err = in.Body.Seek(0, io.SeekStart)
+		ok(t, err)
+		data, _ = io.ReadAll(in.Body)
+		eq(t, string(data), body)
+
+		ok(t, in.Body.Close())
+		if file != nil {
+			_, err := os.Stat(file.Name())
+			eq(t, os.IsNotExist(err), true)
+		}
+	}
+
+	var in input
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{"foo": 42, "bar": "`+strings.Repeat("x", 100)+`"}`))
+	r.Header.Set("Content-Type", "application/json")
+	fails(t, conf.Decode(r, nil, &in), `[400] JSON input: field "foo": expected string, got number`)
+	entries, err := os.ReadDir(tmp)
+	ok(t, err)
+	eq(t, len(entries), 0)
+}
+
+func TestDecode_raw_invalid(t *testing.T) {
+	var in struct {
+		Body []byte `form:",rawbody" json:"-"`
+	}
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`<"foo": "bar" }`))
+	r.Header.Set("Content-Type", "application/json")
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, string(in.Body), `<"foo": "bar" }`)
+}
+
+func TestDecode_fullbody_solo(t *testing.T) {
+	var in struct {
+		Body any `form:",fullbody" json:"-"`
+	}
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{ "foo": "bar" }`))
+	r.Header.Set("Content-Type", "application/json")
+	ok(t, Default.Decode(r, nil, &in))
+	deepEqual(t, in.Body, map[string]any{"foo": "bar"})
+}
+
+func TestDecode_fullbody_mixed(t *testing.T) {
+	var in struct {
+		Body any    `form:",fullbody" json:"-"`
+		Foo  string `json:"foo"`
+	}
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{ "foo": "bar" }`))
+	r.Header.Set("Content-Type", "application/json")
+	ok(t, Default.Decode(r, nil, &in))
+	deepEqual(t, in.Body, map[string]any{"foo": "bar"})
+	eq(t, in.Foo, "bar")
+}
+
+func TestDecode_fallback_body_fullbody(t *testing.T) {
+	type input struct {
+		Raw  []byte `form:",rawbody" json:"-"`
+		Full any    `form:",fullbody" json:"-"`
+		Foo  string `json:"foo"`
+		Bar  int    `json:"bar"`
+	}
+	var in input
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`bar=2&_body=%7B%22foo%22%3A%22x%22%7D`))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Foo, "x")
+	eq(t, in.Bar, 2)
+	eq(t, string(in.Raw), `{"foo":"x"}`)
+	deepEqual(t, in.Full, map[string]any{"foo": "x"})
+
+	var solo struct {
+		Full any `form:",fullbody" json:"-"`
+	}
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?_body=%5B1%2C2%5D", nil)
+	ok(t, Default.Decode(r, nil, &solo))
+	deepEqual(t, solo.Full, []any{1.0, 2.0})
+
+	in = input{}
+	r = httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`bar=2`))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, string(in.Raw), `bar=2`)
+	deepEqual(t, in.Full, nil)
+}
+
+func TestDecode_readonly_ignored(t *testing.T) {
+	in := struct {
+		ID   int    `json:"id" form:",readonly"`
+		Name string `json:"name"`
+	}{ID: 10}
+	r := httptest.NewRequest("POST", "https://example.com/subdir/?id=30", strings.NewReader(`{ "id": 20, "name": "bar" }`))
+	r.Header.Set("Content-Type", "application/json")
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.ID, 10)
+	eq(t, in.Name, "bar")
+}
+
+func TestEncode_declaration_order(t *testing.T) {
+	type output struct {
+		Zulu    string `json:"zulu"`
+		Alpha   string `json:"alpha"`
+		Mike    string `json:"mike"`
+		Charlie string `json:"charlie"`
+	}
+	sm := Default.lookupStruct(reflect.TypeOf(output{}))
+	var names []string
+	for _, fm := range sm.OrderedFields {
+		names = append(names, fm.name)
+	}
+	deepEqual(t, names, []string{"zulu", "alpha", "mike", "charlie"})
+}
+
+func TestEncodeToForm(t *testing.T) {
+	out := struct {
+		Name  string   `json:"name"`
+		Tags  []string `json:"tags" form:",sep=comma"`
+		Page  int      `json:"page,omitempty"`
+		Note  string   `json:"note" form:",omitempty"`
+		Empty string   `json:"empty"`
+	}{Name: "foo bar", Tags: []string{"a", "b"}}
+	body, err := Default.EncodeToForm(&out)
+	ok(t, err)
+	eq(t, body, "empty=&name=foo+bar&tags=a%2Cb")
+
+	// EncodeToValues keeps encoding empty omitempty fields, as it always has
+	values := make(url.Values)
+	Default.EncodeToValues(&out, values)
+	eq(t, values.Encode(), "empty=&name=foo+bar&note=&page=0&tags=a%2Cb")
+}
+
+func TestEncodeToValues_writeonly_skipped(t *testing.T) {
+	out := struct {
+		ID       int    `json:"id" form:",readonly"`
+		Password string `json:"password" form:",writeonly"`
+	}{ID: 10, Password: "secret"}
+	values := make(url.Values)
+	Default.EncodeToValues(&out, values)
+	eq(t, values.Encode(), "id=10")
+}
+
+func TestDecode_range(t *testing.T) {
+	var in struct {
+		Single *ByteRange  `form:",range" json:"-"`
+		Multi  []ByteRange `form:",range" json:"-"`
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/", nil)
+	r.Header.Set("Range", "bytes=0-1023")
+	ok(t, Default.Decode(r, nil, &in))
+	deepEqual(t, in.Single, &ByteRange{0, 1023})
+	deepEqual(t, in.Multi, []ByteRange{{0, 1023}})
+}
+
+func TestDecode_range_multiple(t *testing.T) {
+	var in struct {
+		Ranges []ByteRange `form:",range" json:"-"`
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/", nil)
+	r.Header.Set("Range", "bytes=0-99, 500-, -200")
+	ok(t, Default.Decode(r, nil, &in))
+	deepEqual(t, in.Ranges, []ByteRange{{0, 99}, {500, -1}, {-200, -1}})
+}
+
+func TestDecode_range_missing(t *testing.T) {
+	var in struct {
+		Range *ByteRange `form:",range" json:"-"`
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	deepEqual(t, in.Range, (*ByteRange)(nil))
+}
+
+func TestDecode_range_invalid(t *testing.T) {
+	var in struct {
+		Range ByteRange `form:",range" json:"-"`
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/", nil)
+	r.Header.Set("Range", "bytes=10-5")
+	fails(t, Default.Decode(r, nil, &in), `[416] invalid range "10-5"`)
+	r.Header.Set("Range", "bytes=0-1,5-6")
+	fails(t, Default.Decode(r, nil, &in), `[416] multiple ranges are not supported`)
+	r.Header.Set("Range", "items=0-1")
+	fails(t, Default.Decode(r, nil, &in), `[416] invalid range "items=0-1": only bytes ranges are supported`)
+}
+
+func TestByteRange_Resolve(t *testing.T) {
+	tests := []struct {
+		br         ByteRange
+		size       int64
+		start, end int64
+		ok         bool
+	}{
+		{ByteRange{0, 99}, 1000, 0, 99, true},
+		{ByteRange{500, -1}, 1000, 500, 999, true},
+		{ByteRange{-200, -1}, 1000, 800, 999, true},
+		{ByteRange{-2000, -1}, 1000, 0, 999, true},
+		{ByteRange{900, 2000}, 1000, 900, 999, true},
+		{ByteRange{1000, -1}, 1000, 1000, 999, false},
+	}
+	for _, tt := range tests {
+		start, end, ok := tt.br.Resolve(tt.size)
+		if start != tt.start || end != tt.end || ok != tt.ok {
+			t.Errorf("** %v.Resolve(%d) = %d, %d, %v, wanted %d, %d, %v", tt.br, tt.size, start, end, ok, tt.start, tt.end, tt.ok)
+		}
+	}
+}
+
+func TestDecode_group(t *testing.T) {
+	var in struct {
+		Amount int64 `json:"amount" form:",group"`
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?amount=-1,234,567", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Amount, int64(-1234567))
+
+	values := make(url.Values)
+	Default.EncodeToValues(&in, values)
+	eq(t, values.Get("amount"), "-1,234,567")
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?amount=1234567", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Amount, int64(1234567))
+
+	for _, s := range []string{"1,,,0", ",5", "-,500", "12,34", "1,2345", "1,000,", "1234,567"} {
+		r = httptest.NewRequest("GET", "https://example.com/subdir/?amount="+url.QueryEscape(s), nil)
+		fails(t, Default.Decode(r, nil, &in), fmt.Sprintf("[400] invalid amount: invalid digit grouping in %q", s))
+	}
+}
+
+func TestDecode_json_string_option(t *testing.T) {
+	type input struct {
+		Count int     `json:"count,string"`
+		On    *bool   `json:"on,string"`
+		Name  string  `json:"name,string"`
+		Ratio float64 `json:"ratio,string"`
+	}
+
+	var in input
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{ "count": "42", "on": "true", "name": "\"bob\"", "ratio": "0.5" }`))
+	r.Header.Set("Content-Type", "application/json")
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Count, 42)
+	eq(t, *in.On, true)
+	eq(t, in.Name, "bob")
+	eq(t, in.Ratio, 0.5)
+
+	in = input{}
+	r = httptest.NewRequest("GET", `https://example.com/subdir/?count=%2242%22&on=%22true%22&name=%22bob%22&ratio=0.5`, nil)
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Count, 42)
+	eq(t, *in.On, true)
+	eq(t, in.Name, `"bob"`) // strings are taken verbatim
+	eq(t, in.Ratio, 0.5)
+
+	r = httptest.NewRequest("GET", `https://example.com/subdir/?count=%2242`, nil)
+	fails(t, Default.Decode(r, nil, &in), `[400] invalid count: strconv.ParseInt: parsing "\"42": invalid syntax`)
+
+	values := make(url.Values)
+	Default.EncodeToValues(&in, values)
+	eq(t, values.Get("count"), "42")
+}
+
+func TestDecode_duration(t *testing.T) {
+	var in struct {
+		Timeout  time.Duration   `json:"timeout"`
+		Interval *time.Duration  `json:"interval"`
+		Delays   []time.Duration `json:"delays" form:",sep=comma"`
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?timeout=1h30m&interval=30s&delays=1s,500ms", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Timeout, 90*time.Minute)
+	eq(t, *in.Interval, 30*time.Second)
+	deepEqual(t, in.Delays, []time.Duration{time.Second, 500 * time.Millisecond})
+
+	values := make(url.Values)
+	Default.EncodeToValues(&in, values)
+	eq(t, values.Get("timeout"), "1h30m0s")
+	eq(t, values.Get("interval"), "30s")
+	eq(t, values.Get("delays"), "1s,500ms")
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?timeout=30", nil)
+	fails(t, Default.Decode(r, nil, &in), `[400] invalid timeout: time: missing unit in duration "30"`)
+}
+
+func TestDecode_regexp(t *testing.T) {
+	var in struct {
+		Filter *regexp.Regexp `json:"filter"`
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?filter=%5Ea%2Bb%24", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Filter.String(), "^a+b$")
+	eq(t, in.Filter.MatchString("aab"), true)
+
+	values := make(url.Values)
+	Default.EncodeToValues(&in, values)
+	eq(t, values.Get("filter"), "^a+b$")
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?filter=a(b", nil)
+	fails(t, Default.Decode(r, nil, &in), "[400] invalid filter: invalid pattern: error parsing regexp: missing closing ): `a(b`")
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?filter=", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Filter, nil)
+}
+
+func TestDecode_time_layouts(t *testing.T) {
+	type input struct {
+		Day   time.Time  `json:"day"`
+		Until *time.Time `json:"until"`
+	}
+	conf := Default.Clone()
+	conf.TimeLayouts = []string{"2006-01-02", time.RFC3339, "02.01.2006"}
+
+	var in input
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?day=2024-03-15&until=16.03.2024", nil)
+	ok(t, conf.Decode(r, nil, &in))
+	eq(t, in.Day, time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC))
+	eq(t, *in.Until, time.Date(2024, 3, 16, 0, 0, 0, 0, time.UTC))
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?day=2024-03-15T10:00:00Z", nil)
+	ok(t, conf.Decode(r, nil, &in))
+	eq(t, in.Day, time.Date(2024, 3, 15, 10, 0, 0, 0, time.UTC))
+
+	values := make(url.Values)
+	conf.EncodeToValues(&in, values)
+	eq(t, values.Get("day"), "2024-03-15")
+	eq(t, values.Get("until"), "2024-03-16")
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?day=15/03/2024", nil)
+	fails(t, conf.Decode(r, nil, &in), `[400] invalid day: invalid time "15/03/2024", expected one of: 2006-01-02, 2006-01-02T15:04:05Z07:00, 02.01.2006`)
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?day=2024-03-15", nil)
+	fails(t, Default.Decode(r, nil, &in), `[400] invalid day: parsing time "2024-03-15" as "2006-01-02T15:04:05Z07:00": cannot parse "" as "T"`)
+}
+
+func TestDecode_decimalcomma(t *testing.T) {
+	var in struct {
+		Price   float64   `json:"price" form:",decimalcomma"`
+		Weight  *float32  `json:"weight" form:",decimalcomma"`
+		Samples []float64 `json:"samples" form:",decimalcomma,sep=semicolon"`
+		Plain   float64   `json:"plain"`
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?price=3,14&weight=0.5&samples=1,5%3B2,25&plain=2.5", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Price, 3.14)
+	eq(t, *in.Weight, float32(0.5))
+	deepEqual(t, in.Samples, []float64{1.5, 2.25})
+	eq(t, in.Plain, 2.5)
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?plain=3,14", nil)
+	fails(t, Default.Decode(r, nil, &in), `[400] invalid plain: strconv.ParseFloat: parsing "3,14": invalid syntax`)
+}
+
+func TestGroupDigits(t *testing.T) {
+	for _, tt := range []struct{ input, expected string }{
+		{"0", "0"},
+		{"999", "999"},
+		{"-999", "-999"},
+		{"1000", "1,000"},
+		{"123456", "123,456"},
+		{"-1234567", "-1,234,567"},
+	} {
+		eq(t, groupDigits(tt.input), tt.expected)
+	}
+}
+
+func TestDecode_big_rat(t *testing.T) {
+	var in struct {
+		Rate  *big.Rat `json:"rate"`
+		Ratio *big.Rat `json:"ratio"`
+		None  *big.Rat `json:"none"`
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?rate=1/3&ratio=0.25&none=", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Rate.String(), "1/3")
+	eq(t, in.Ratio.String(), "1/4")
+	deepEqual(t, in.None, (*big.Rat)(nil))
+
+	values := make(url.Values)
+	Default.EncodeToValues(&in, values)
+	eq(t, values.Encode(), "none=&rate=1%2F3&ratio=1%2F4")
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?rate=1/x", nil)
+	fails(t, Default.Decode(r, nil, &in), `[400] invalid rate: invalid fraction "1/x"`)
+}
+
+func TestDecode_text_unmarshaler_pointer(t *testing.T) {
+	var in struct {
+		Time *time.Time `json:"time"`
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?time=2023-01-02T03:04:05Z", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Time.Equal(time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)), true)
+}
+
+func TestDecode_sql_null(t *testing.T) {
+	var in struct {
+		Name  sql.NullString `json:"name"`
+		Age   sql.NullInt64  `json:"age"`
+		Score sql.NullInt64  `json:"score"`
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?name=foo&age=", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Name, sql.NullString{String: "foo", Valid: true})
+	eq(t, in.Age, sql.NullInt64{})
+	eq(t, in.Score, sql.NullInt64{})
+
+	values := make(url.Values)
+	Default.EncodeToValues(&in, values)
+	eq(t, values.Encode(), "age=&name=foo&score=")
+}
+
+func TestDecode_nullable(t *testing.T) {
+	one := 1
+	in := struct {
+		Name   sql.NullString `json:"name" form:",nullable"`
+		Count  *int           `json:"count" form:",nullable"`
+		Kept   *int           `json:"kept"`
+		Header *string        `json:"-" form:"X-Foo,header,nullable"`
+	}{
+		Name:  sql.NullString{String: "old", Valid: true},
+		Count: &one,
+		Kept:  &one,
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Name, sql.NullString{})
+	eq(t, in.Count, nil)
+	eq(t, in.Kept, &one)
+	eq(t, in.Header, nil)
+}
+
+func TestDecode_nestedform(t *testing.T) {
+	type address struct {
+		City string `json:"city"`
+		Zip  int    `json:"zip"`
+	}
+	var in struct {
+		Name    string   `json:"name"`
+		Address address  `json:"address" form:",nestedform"`
+		Billing *address `json:"billing" form:",nestedform"`
+	}
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	w.WriteField("name", "foo")
+	w.WriteField("address", "city=Paris&zip=75001")
+	w.Close()
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", body)
+	r.Header.Set("Content-Type", w.FormDataContentType())
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Name, "foo")
+	eq(t, in.Address, address{"Paris", 75001})
+	eq(t, in.Billing, nil)
+
+	values := make(url.Values)
+	Default.EncodeToValues(&in, values)
+	eq(t, values.Get("address"), "city=Paris&zip=75001")
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?address=zip%3Dx", nil)
+	fails(t, Default.Decode(r, nil, &in), `[400] invalid address: invalid zip: strconv.ParseInt: parsing "x": invalid syntax`)
+}
+
+func TestDecode_multipart_subtypes(t *testing.T) {
+	var in struct {
+		Name string `json:"name"`
+	}
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	w.WriteField("name", "foo")
+	w.Close()
+
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", bytes.NewReader(body.Bytes()))
+	r.Header.Set("Content-Type", "multipart/mixed; boundary="+w.Boundary())
+	fails(t, Default.Decode(r, nil, &in), "[415] unsupported content type multipart/mixed")
+
+	r = httptest.NewRequest("POST", "https://example.com/subdir/", bytes.NewReader(body.Bytes()))
+	r.Header.Set("Content-Type", "multipart/form-data; boundary=wrong")
+	fails(t, Default.Decode(r, nil, &in), "[400] multipart: NextPart: EOF")
+
+	r = httptest.NewRequest("POST", "https://example.com/subdir/", bytes.NewReader(body.Bytes()))
+	r.Header.Set("Content-Type", w.FormDataContentType())
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Name, "foo")
+}
+
+func TestDecode_multipart_files(t *testing.T) {
+	type input struct {
+		Name        string                  `json:"name"`
+		Avatar      *multipart.FileHeader   `json:"-" form:"avatar"`
+		Attachments []*multipart.FileHeader `json:"-" form:"attachments,optional"`
+	}
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	w.WriteField("name", "foo")
+	fw, _ := w.CreateFormFile("avatar", "me.png")
+	fw.Write([]byte("PNG"))
+	for _, name := range []string{"a.txt", "b.txt"} {
+		fw, _ = w.CreateFormFile("attachments", name)
+		fw.Write([]byte(name))
+	}
+	w.Close()
+
+	var in input
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", body)
+	r.Header.Set("Content-Type", w.FormDataContentType())
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Name, "foo")
+	eq(t, in.Avatar.Filename, "me.png")
+	eq(t, in.Avatar.Size, int64(3))
+	eq(t, len(in.Attachments), 2)
+	eq(t, in.Attachments[1].Filename, "b.txt")
+
+	in = input{}
+	r = httptest.NewRequest("POST", "https://example.com/subdir/?name=foo", nil)
+	fails(t, Default.Decode(r, nil, &in), `[400] missing file avatar`)
+	deepEqual(t, Default.RequiredFields(reflect.TypeOf(in)), []string{"avatar"})
+}
+
+func TestDecode_multipart_filebytes(t *testing.T) {
+	var in struct {
+		Icon []byte `json:"-" form:"icon,filebytes=icon"`
+		Logo []byte `json:"-" form:"logo,filebytes=logo,optional"`
+	}
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	fw, _ := w.CreateFormFile("icon", "icon.png")
+	fw.Write([]byte("PNGDATA"))
+	w.Close()
+
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", bytes.NewReader(body.Bytes()))
+	r.Header.Set("Content-Type", w.FormDataContentType())
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, string(in.Icon), "PNGDATA")
+	eq(t, len(in.Logo), 0)
+
+	conf := Default.Clone()
+	conf.MaxFileBytes = 4
+	r = httptest.NewRequest("POST", "https://example.com/subdir/", bytes.NewReader(body.Bytes()))
+	r.Header.Set("Content-Type", w.FormDataContentType())
+	fails(t, conf.Decode(r, nil, &in), `[413] file icon is too large`)
+
+	conf.CollectAllErrors = true
+	body = &bytes.Buffer{}
+	w = multipart.NewWriter(body)
+	fw, _ = w.CreateFormFile("icon", "icon.png")
+	fw.Write([]byte("PNGDATA"))
+	fw, _ = w.CreateFormFile("logo", "logo.png")
+	fw.Write([]byte("PNGDATA"))
+	w.Close()
+	r = httptest.NewRequest("POST", "https://example.com/subdir/", body)
+	r.Header.Set("Content-Type", w.FormDataContentType())
+	var multi *MultiError
+	eq(t, errors.As(conf.Decode(r, nil, &in), &multi), true)
+	eq(t, len(multi.FieldErrors()), 2)
+	fails(t, multi.FieldErrors()["logo"], `[413] file logo is too large`)
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/", nil)
+	fails(t, Default.Decode(r, nil, &in), `[400] missing file icon`)
+}
+
+func TestDecode_header_query_alternate(t *testing.T) {
+	type input struct {
+		APIKey string `json:"-" form:"X-API-Key,header,query=api_key,query=key"`
+	}
+	tests := []struct {
+		header string
+		query  string
+		want   string
+		err    string
+	}{
+		{"hdr", "", "hdr", ""},
+		{"", "api_key=q", "q", ""},
+		{"", "key=q2", "q2", ""},
+		{"", "api_key=q&key=q2", "q", ""},
+		{"hdr", "api_key=q", "hdr", ""},
+		{"", "", "", "[400] missing header X-API-Key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header+"&"+tt.query, func(t *testing.T) {
+			var in input
+			r := httptest.NewRequest("GET", "https://example.com/subdir/?"+tt.query, nil)
+			if tt.header != "" {
+				r.Header.Set("X-API-Key", tt.header)
+			}
+			res, err := Default.DecodeWith(r, nil, &in, nil)
+			if tt.err != "" {
+				fails(t, err, tt.err)
+				return
+			}
+			ok(t, err)
+			eq(t, in.APIKey, tt.want)
+			eq(t, len(res.Warnings), 0)
+		})
+	}
+}
+
+func TestDecode_remoteip(t *testing.T) {
+	var in struct {
+		IP   string     `json:"-" form:",remoteip"`
+		Addr netip.Addr `json:"-" form:",remoteip"`
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/", nil)
+	r.RemoteAddr = "[2001:db8::1]:4321"
+	r.Header.Set("X-Forwarded-For", "10.6.6.6, 203.0.113.7")
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.IP, "2001:db8::1")
+	eq(t, in.Addr, netip.MustParseAddr("2001:db8::1"))
+
+	conf := Default.Clone()
+	conf.TrustForwardedHeaders = true
+	ok(t, conf.Decode(r, nil, &in))
+	eq(t, in.IP, "203.0.113.7")
+
+	r.Header.Add("X-Forwarded-For", "203.0.113.8")
+	ok(t, conf.Decode(r, nil, &in))
+	eq(t, in.IP, "203.0.113.8")
+
+	r.Header.Del("X-Forwarded-For")
+	r.Header.Set("X-Real-IP", "198.51.100.2")
+	ok(t, conf.Decode(r, nil, &in))
+	eq(t, in.Addr, netip.MustParseAddr("198.51.100.2"))
+
+	r.Header.Set("X-Real-IP", "bogus")
+	fails(t, conf.Decode(r, nil, &in), `[400] invalid client IP: ParseAddr("bogus"): unable to parse IP`)
+}
+
+func TestDecode_map_brackets(t *testing.T) {
+	var in struct {
+		Meta   map[string]string `json:"meta"`
+		Counts map[string]int    `json:"counts"`
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?meta[color]=red&meta[size]=lg&meta[size]=xl&counts[a]=1", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	deepEqual(t, in.Meta, map[string]string{"color": "red", "size": "xl"})
+	deepEqual(t, in.Counts, map[string]int{"a": 1})
+
+	values := make(url.Values)
+	Default.EncodeToValues(&in, values)
+	eq(t, values.Encode(), "counts%5Ba%5D=1&meta%5Bcolor%5D=red&meta%5Bsize%5D=xl")
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?counts[a]=x", nil)
+	fails(t, Default.Decode(r, nil, &in), `[400] invalid counts[a]: strconv.ParseInt: parsing "x": invalid syntax`)
+}
+
+func TestDecodeBatch(t *testing.T) {
+	type row struct {
+		Name string `json:"name" form:",required"`
+		Age  int    `json:"age"`
+	}
+	var rows []row
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`[{"name": "a", "age": 1}, {"name": "b", "age": "x"}, {"age": 3}, {"name": "d"}]`))
+	r.Header.Set("Content-Type", "application/json")
+	errs, err := Default.DecodeBatch(r, &rows)
+	ok(t, err)
+	deepEqual(t, rows, []row{{"a", 1}, {"d", 0}})
+	eq(t, len(errs), 2)
+	eq(t, errs[0].Index, 1)
+	eq(t, errs[0].Error(), `item 1: [400] JSON input: field "age": expected number, got string`)
+	eq(t, errs[1].Index, 2)
+	fails(t, errs[1].Err, `[400] missing parameter name`)
+
+	var ptrs []*row
+	r = httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{"name": "a"}`))
+	r.Header.Set("Content-Type", "application/json")
+	_, err = Default.DecodeBatch(r, &ptrs)
+	fails(t, err, `[400] JSON input: expected an array`)
+}
+
+func TestDecode_url_values(t *testing.T) {
+	var values url.Values
+	r := httptest.NewRequest("POST", "https://example.com/subdir/?a=1&b=2", strings.NewReader("a=3&c=4"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ok(t, Default.Decode(r, nil, &values))
+	deepEqual(t, values, url.Values{"a": {"3", "1"}, "b": {"2"}, "c": {"4"}})
+
+	values = nil
+	r = httptest.NewRequest("POST", "https://example.com/subdir/?a=1", strings.NewReader(`{"a": 2}`))
+	r.Header.Set("Content-Type", "application/json")
+	fails(t, Default.Decode(r, nil, &values), `[415] unsupported content type application/json`)
+
+	// other bodies are skipped like for structs, subject to ParseQueryAlways
+	conf := Default.Clone()
+	for _, always := range []bool{false, true} {
+		conf.ParseQueryAlways = always
+		values = nil
+		r = httptest.NewRequest("POST", "https://example.com/subdir/?a=1", strings.NewReader(`a=2`))
+		r.Header.Set("Content-Type", "text/plain")
+		ok(t, conf.Decode(r, nil, &values))
+		var in struct {
+			A string `json:"a"`
+		}
+		r = httptest.NewRequest("POST", "https://example.com/subdir/?a=1", strings.NewReader(`a=2`))
+		r.Header.Set("Content-Type", "text/plain")
+		ok(t, conf.Decode(r, nil, &in))
+		eq(t, values.Get("a"), in.A)
+		eq(t, in.A != "", always)
+	}
+}
+
+func TestDecode_any_map(t *testing.T) {
+	var m map[string]any
+	r := httptest.NewRequest("POST", "https://example.com/subdir/?a=1&d=x", strings.NewReader(`{"a": 2, "b": {"c": [true]}}`))
+	r.Header.Set("Content-Type", "application/json")
+	ok(t, Default.Decode(r, nil, &m))
+	deepEqual(t, m, map[string]any{"a": "1", "b": map[string]any{"c": []any{true}}, "d": "x"})
+
+	m = nil
+	r = httptest.NewRequest("POST", "https://example.com/subdir/?a=1&b=2", strings.NewReader("a=3&c=4"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ok(t, Default.Decode(r, nil, &m))
+	deepEqual(t, m, map[string]any{"a": []string{"3", "1"}, "b": "2", "c": "4"})
+
+	m = nil
+	r = httptest.NewRequest("GET", "https://example.com/subdir/", nil)
+	ok(t, Default.Decode(r, nil, &m))
+	deepEqual(t, m, map[string]any{})
+
+	r = httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`[1]`))
+	r.Header.Set("Content-Type", "application/json")
+	eq(t, Default.Decode(r, nil, &m).(*Error).HTTPCode(), 400)
+}
+
+func TestDecode_body_too_large(t *testing.T) {
+	var in struct {
+		Foo string `json:"foo"`
+	}
+	var raw struct {
+		Body []byte `form:",rawbody" json:"-"`
+	}
+	big := strings.Repeat("x", 100)
+	for _, tt := range []struct {
+		ctype, body string
+		dest        any
+	}{
+		{"application/json", `{"foo": "` + big + `"}`, &in},
+		{"application/x-www-form-urlencoded", "foo=" + big, &in},
+		{"multipart/form-data; boundary=b", "--b\r\nContent-Disposition: form-data; name=\"foo\"\r\n\r\n" + big + "\r\n--b--\r\n", &in},
+		{"application/octet-stream", big, &raw},
+	} {
+		r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(tt.body))
+		r.Header.Set("Content-Type", tt.ctype)
+		LimitBody(httptest.NewRecorder(), r, 80)
+		err := Default.Decode(r, nil, tt.dest)
+		eq(t, err.(*Error).HTTPCode(), 413)
+	}
+
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{"foo": "bar"}`))
+	r.Header.Set("Content-Type", "application/json")
+	LimitBody(httptest.NewRecorder(), r, 80)
+	ok(t, Default.Decode(r, nil, &in))
+}
+
+func TestDecode_case_insensitive_fields(t *testing.T) {
+	type input struct {
+		Foo    string `json:"foo"`
+		UserID int    `json:"userId"`
+		Active bool   `json:"active" form:",flag"`
+	}
+	conf := Default.Clone()
+	conf.CaseInsensitiveFields = true
+
+	var in input
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?Foo=bar&USERID=42&Active", nil)
+	ok(t, conf.Decode(r, nil, &in))
+	eq(t, in, input{"bar", 42, true})
+
+	in = input{}
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?FOO=a&foo=b", nil)
+	ok(t, conf.Decode(r, nil, &in))
+	eq(t, in.Foo, "b")
+
+	in = input{}
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?Foo=bar", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Foo, "")
+
+	var clash struct {
+		Foo  string `json:"foo"`
+		Foo2 string `json:"Foo"`
+	}
+	r = httptest.NewRequest("GET", "https://example.com/subdir/", nil)
+	ok(t, Default.Decode(r, nil, &clash))
+	defer func() {
+		e := recover()
+		if e == nil {
+			t.Fatal("** expected a panic")
+		}
+		eq(t, e.(error).Error(), `struct struct { Foo string "json:\"foo\""; Foo2 string "json:\"Foo\"" } has fields "foo" and "Foo" that differ only by case, which CaseInsensitiveFields cannot tell apart`)
+	}()
+	conf.Decode(r, nil, &clash)
+}
+
+func TestDecode_default(t *testing.T) {
+	type input struct {
+		Page    int      `json:"page" form:",default=1"`
+		Sort    string   `json:"sort" form:",default=name"`
+		Tags    []string `json:"tags" form:",default=a b"`
+		Version string   `json:"-" form:"X-Version,header,default=v1"`
+	}
+	var in input
+	r := httptest.NewRequest("GET", "https://example.com/subdir/", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	deepEqual(t, in, input{1, "name", []string{"a", "b"}, "v1"})
+
+	in = input{}
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?page=0&sort=", nil)
+	r.Header.Set("X-Version", "v2")
+	ok(t, Default.Decode(r, nil, &in))
+	deepEqual(t, in, input{0, "", []string{"a", "b"}, "v2"})
+
+	in = input{}
+	r = httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{"page": 0}`))
+	r.Header.Set("Content-Type", "application/json")
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Page, 0)
+	eq(t, in.Sort, "name")
+	deepEqual(t, Default.RequiredFields(reflect.TypeOf(in)), []string(nil))
+
+	var bad struct {
+		Page int `json:"page" form:",default=first"`
+	}
+	defer func() {
+		e := recover()
+		if e == nil {
+			t.Fatal("** expected a panic")
+		}
+		eq(t, e.(error).Error(), `field struct { Page int "json:\"page\" form:\",default=first\"" }.Page has invalid default value "first" in form:",default=first" tag: strconv.ParseInt: parsing "first": invalid syntax`)
+	}()
+	Default.Decode(r, nil, &bad)
+}
+
+func TestDecode_units(t *testing.T) {
+	conf := Default.Clone()
+	conf.RegisterUnit("KB", 1024)
+	conf.RegisterUnit("MB", 1024*1024)
+	conf.RegisterUnit("m", 1)
+	conf.RegisterUnit("km", 1000)
+
+	type input struct {
+		Size     int64    `json:"size" form:",units"`
+		Distance float64  `json:"distance" form:",units"`
+		Limits   []uint32 `json:"limits" form:",units"`
+		Plain    *int     `json:"plain" form:",units"`
+	}
+	var in input
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?size=10MB&distance=5.5km&limits=1KB+2&plain=7", nil)
+	ok(t, conf.Decode(r, nil, &in))
+	eq(t, in.Size, int64(10*1024*1024))
+	eq(t, in.Distance, 5500.0)
+	deepEqual(t, in.Limits, []uint32{1024, 2})
+	eq(t, *in.Plain, 7)
+
+	values := make(url.Values)
+	conf.EncodeToValues(&in, values)
+	eq(t, values.Get("size"), "10485760")
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?size=1.5", nil)
+	fails(t, conf.Decode(r, nil, &in), `[400] invalid size: strconv.ParseInt: parsing "1.5": invalid syntax`)
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?size=0.3KB", nil)
+	fails(t, conf.Decode(r, nil, &in), `[400] invalid size: "0.3KB" is not a whole number of base units`)
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?size=xKB", nil)
+	fails(t, conf.Decode(r, nil, &in), `[400] invalid size: invalid quantity "xKB"`)
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?size=10GB", nil)
+	fails(t, conf.Decode(r, nil, &in), `[400] invalid size: strconv.ParseInt: parsing "10GB": invalid syntax`)
+}
+
+func TestValidateRequest(t *testing.T) {
+	type input struct {
+		Name string `json:"name" form:",required"`
+		Age  int    `json:"age"`
+	}
+	typ := reflect.TypeOf(input{})
+
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{"name": "foo", "age": 42}`))
+	r.Header.Set("Content-Type", "application/json")
+	ok(t, Default.ValidateRequest(r, nil, typ))
+	var in input
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in, input{"foo", 42})
+
+	r = httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`age=x`))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	fails(t, Default.ValidateRequest(r, nil, typ), `[400] invalid age: strconv.ParseInt: parsing "x": invalid syntax`)
+	eq(t, r.Form == nil, true)
+	body, _ := io.ReadAll(r.Body)
+	eq(t, string(body), "age=x")
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?age=1", nil)
+	fails(t, Default.ValidateRequest(r, nil, typ), `[400] missing parameter name`)
+}
+
+func TestValidateRequest_read_error(t *testing.T) {
+	typ := reflect.TypeOf(struct {
+		Name string `json:"name"`
+	}{})
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", io.MultiReader(strings.NewReader(`{"name": `), iotest.ErrReader(io.ErrUnexpectedEOF)))
+	r.Header.Set("Content-Type", "application/json")
+	fails(t, Default.ValidateRequest(r, nil, typ), `[400] unexpected EOF`)
+	body, err := io.ReadAll(r.Body)
+	eq(t, string(body), `{"name": `)
+	eq(t, err == io.ErrUnexpectedEOF, true)
+}
+
+func TestValidateRequest_temp_files(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	conf := Default.Clone()
+	conf.MaxMultipartMemory = 1024
+	conf.RawBodyMemoryLimit = 16
+
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	fw, _ := w.CreateFormFile("file", "big.bin")
+	fw.Write(bytes.Repeat([]byte("x"), 64*1024))
+	w.Close()
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", body)
+	r.Header.Set("Content-Type", w.FormDataContentType())
+	ok(t, conf.ValidateRequest(r, nil, reflect.TypeOf(struct {
+		File *multipart.FileHeader `form:"file" json:"-"`
+	}{})))
+
+	r = httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{"foo": "`+strings.Repeat("x", 100)+`"}`))
+	r.Header.Set("Content-Type", "application/json")
+	ok(t, conf.ValidateRequest(r, nil, reflect.TypeOf(struct {
+		Body io.ReadSeekCloser `form:",rawbody" json:"-"`
+		Foo  string            `json:"foo"`
+	}{})))
+
+	entries, err := os.ReadDir(tmp)
+	ok(t, err)
+	eq(t, len(entries), 0)
+}
+
+func TestDecode_collect_all_errors(t *testing.T) {
+	var in struct {
+		Name  string `json:"name" form:",required"`
+		Age   int    `json:"age"`
+		Score int    `json:"score"`
+		Email string `json:"email" form:",requiredwith=name"`
+		Auth  string `json:"-" form:"Authorization,header"`
+	}
+	conf := Default.Clone()
+	conf.CollectAllErrors = true
+
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?age=x&score=y", nil)
+	err := conf.Decode(r, nil, &in)
+	fails(t, err, `[400] missing header Authorization; invalid age: strconv.ParseInt: parsing "x": invalid syntax; missing parameter name; invalid score: strconv.ParseInt: parsing "y": invalid syntax`)
+	var multi *MultiError
+	eq(t, errors.As(err, &multi), true)
+	eq(t, len(multi.FieldErrors()), 4)
+	fails(t, multi.FieldErrors()["name"], `[400] missing parameter name`)
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?age=x&score=1", nil)
+	fails(t, Default.Decode(r, nil, &in), `[400] invalid age: strconv.ParseInt: parsing "x": invalid syntax`)
+}
+
+func TestDecode_defaultfrom(t *testing.T) {
+	type input struct {
+		Country         string `json:"country"`
+		BillingCountry  string `json:"billing_country" form:",defaultfrom=country"`
+		ShippingCountry string `json:"shipping_country" form:",defaultfrom=billing_country"`
+	}
+	var in input
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?country=FR", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in, input{"FR", "FR", "FR"})
+
+	in = input{}
+	r = httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{"country": "FR", "billing_country": "DE"}`))
+	r.Header.Set("Content-Type", "application/json")
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in, input{"FR", "DE", "DE"})
+
+	var bad struct {
+		Count int    `json:"count"`
+		Label string `json:"label" form:",defaultfrom=count"`
+	}
+	defer func() {
+		e := recover()
+		if e == nil {
+			t.Fatal("** expected a panic")
+		}
+		eq(t, e.(error).Error(), `field struct { Count int "json:\"count\""; Label string "json:\"label\" form:\",defaultfrom=count\"" }.Label is string and cannot take its default from field "count" of type int`)
+	}()
+	Default.Decode(r, nil, &bad)
+}
+
+func TestDecode_aliasof(t *testing.T) {
+	type input struct {
+		ID       int64  `json:"id"`
+		LegacyID int64  `json:"legacy_id" form:",aliasof=id"`
+		Owner    string `json:"owner" form:",defaultfrom=user"`
+		User     string `json:"user"`
+		OldUser  string `json:"username" form:",aliasof=user"`
+	}
+	for _, tt := range []struct {
+		query string
+		e     input
+	}{
+		{"legacy_id=7&username=bob", input{7, 7, "bob", "bob", "bob"}},
+		{"id=8&legacy_id=7", input{8, 7, "", "", ""}},
+		{"id=8", input{8, 0, "", "", ""}},
+		{"user=amy&username=bob", input{0, 0, "amy", "amy", "bob"}},
+	} {
+		var in input
+		r := httptest.NewRequest("GET", "https://example.com/subdir/?"+tt.query, nil)
+		ok(t, Default.Decode(r, nil, &in))
+		eq(t, in, tt.e)
+	}
+
+	var bad struct {
+		ID    int    `json:"id"`
+		OldID string `json:"old_id" form:",aliasof=id"`
+	}
+	defer func() {
+		e := recover()
+		if e == nil {
+			t.Fatal("** expected a panic")
+		}
+		eq(t, e.(error).Error(), `field struct { ID int "json:\"id\""; OldID string "json:\"old_id\" form:\",aliasof=id\"" }.OldID is string and cannot be an alias of field "id" of type int`)
+	}()
+	Default.Decode(httptest.NewRequest("GET", "https://example.com/subdir/", nil), nil, &bad)
+}
+
+func TestDecode_netip(t *testing.T) {
+	type input struct {
+		IP    netip.Addr     `json:"ip"`
+		CIDR  netip.Prefix   `json:"cidr"`
+		Peers []netip.Addr   `json:"peers" form:",sep=comma"`
+		Nets  []netip.Prefix `json:"nets"`
+		Gate  *netip.Addr    `json:"gate"`
+	}
+	var in input
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?ip=10.0.0.1&cidr=10.0.0.0/8&peers=10.0.0.2,::1&nets=192.168.0.0/16+fd00::/8&gate=", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.IP, netip.MustParseAddr("10.0.0.1"))
+	eq(t, in.CIDR, netip.MustParsePrefix("10.0.0.0/8"))
+	deepEqual(t, in.Peers, []netip.Addr{netip.MustParseAddr("10.0.0.2"), netip.MustParseAddr("::1")})
+	deepEqual(t, in.Nets, []netip.Prefix{netip.MustParsePrefix("192.168.0.0/16"), netip.MustParsePrefix("fd00::/8")})
+	eq(t, in.Gate, nil)
+
+	values := make(url.Values)
+	Default.EncodeToValues(&in, values)
+	eq(t, values.Get("peers"), "10.0.0.2,::1")
+	eq(t, values.Get("gate"), "")
+
+	in = input{}
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?ip=&cidr=", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.IP, netip.Addr{})
+	eq(t, in.CIDR, netip.Prefix{})
+
+	values = make(url.Values)
+	Default.EncodeToValues(&in, values)
+	eq(t, values.Encode(), "cidr=&gate=&ip=&nets=&peers=")
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?ip=300.1.1.1", nil)
+	fails(t, Default.Decode(r, nil, &in), `[400] invalid ip: ParseAddr("300.1.1.1"): IPv4 field has value >255`)
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?peers=1.1.1.1,x", nil)
+	fails(t, Default.Decode(r, nil, &in), `[400] invalid peers: ParseAddr("x"): unable to parse IP`)
+}
+
+type testPeriod struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Unit  string `json:"unit"`
+}
+
+func (p *testPeriod) AfterDecode(r *http.Request) error {
+	p.Unit = strings.ToLower(p.Unit)
+	if p.Start > p.End {
+		return errors.New("start must not be after end")
+	}
+	if p.Unit == "eon" {
+		return &Error{http.StatusUnprocessableEntity, "eons are too long", nil}
+	}
+	return nil
+}
+
+func TestDecode_after_decode(t *testing.T) {
+	var in testPeriod
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?start=1&end=2&unit=DAY", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in, testPeriod{1, 2, "day"})
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?start=3&end=2", nil)
+	fails(t, Default.Decode(r, nil, &in), `[400] start must not be after end`)
+
+	in = testPeriod{}
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?unit=eon", nil)
+	fails(t, Default.Decode(r, nil, &in), `[422] eons are too long`)
+}
+
+func TestDecode_gob(t *testing.T) {
+	type input struct {
+		Name  string   `json:"name"`
+		Tags  []string `json:"tags"`
+		Limit int      `json:"limit"`
+	}
+	var body bytes.Buffer
+	ok(t, gob.NewEncoder(&body).Encode(input{Name: "foo", Tags: []string{"a", "b"}}))
+
+	conf := Default.Clone()
+	conf.AllowGob = true
+	var in input
+	r := httptest.NewRequest("POST", "https://example.com/subdir/?limit=5", bytes.NewReader(body.Bytes()))
+	r.Header.Set("Content-Type", "application/gob")
+	ok(t, conf.Decode(r, nil, &in))
+	deepEqual(t, in, input{"foo", []string{"a", "b"}, 5})
+
+	r = httptest.NewRequest("POST", "https://example.com/subdir/", bytes.NewReader(body.Bytes()))
+	r.Header.Set("Content-Type", "application/gob")
+	fails(t, Default.Decode(r, nil, &in), `[415] gob input not allowed`)
+
+	r = httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader("junk"))
+	r.Header.Set("Content-Type", "application/gob")
+	eq(t, conf.Decode(r, nil, &in).(*Error).HTTPCode(), 400)
+}
+
+func TestDecode_gob_default_required(t *testing.T) {
+	type input struct {
+		Name  string `json:"name" form:",required"`
+		Page  int    `json:"page" form:",default=1"`
+		Token string `json:"token" form:",queryonly,required"`
+	}
+	conf := Default.Clone()
+	conf.AllowGob = true
+	encode := func(v input) *bytes.Reader {
+		var body bytes.Buffer
+		ok(t, gob.NewEncoder(&body).Encode(v))
+		return bytes.NewReader(body.Bytes())
+	}
+
+	var in input
+	r := httptest.NewRequest("POST", "https://example.com/subdir/?token=t", encode(input{Name: "alice", Page: 7}))
+	r.Header.Set("Content-Type", "application/gob")
+	ok(t, conf.Decode(r, nil, &in))
+	eq(t, in, input{"alice", 7, "t"})
+
+	in = input{}
+	r = httptest.NewRequest("POST", "https://example.com/subdir/", encode(input{Name: "alice", Page: 7}))
+	r.Header.Set("Content-Type", "application/gob")
+	fails(t, conf.Decode(r, nil, &in), `[400] missing parameter token`)
+}
+
+func TestDecode_headerlist(t *testing.T) {
+	type input struct {
+		Accept  []string `json:"-" form:"Accept,header,headerlist"`
+		Vary    []string `json:"-" form:"Vary,header,headerlist,optional"`
+		Request []string `json:"-" form:"Access-Control-Request-Headers,header,headerlist,optional"`
+	}
+	var in input
+	r := httptest.NewRequest("GET", "https://example.com/subdir/", nil)
+	r.Header.Set("Accept", "text/html, application/xhtml+xml;q=0.9 ,*/*; q=0.8,")
+	r.Header.Add("Vary", "Accept-Encoding")
+	r.Header.Add("Vary", "Origin, Cookie")
+	ok(t, Default.Decode(r, nil, &in))
+	deepEqual(t, in, input{[]string{"text/html", "application/xhtml+xml", "*/*"}, []string{"Accept-Encoding", "Origin", "Cookie"}, nil})
+
+	h := make(http.Header)
+	ok(t, Default.EncodeToHeader(&in, h))
+	deepEqual(t, h, http.Header{"Accept": {"text/html, application/xhtml+xml, */*"}, "Vary": {"Accept-Encoding, Origin, Cookie"}})
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/", nil)
+	fails(t, Default.Decode(r, nil, &in), "[400] missing header Accept")
+
+	var bad struct {
+		Accept string `json:"-" form:"Accept,header,headerlist"`
+	}
+	defer func() {
+		e := recover()
+		if e == nil {
+			t.Fatal("** expected a panic")
+		}
+		eq(t, e.(error).Error(), `field struct { Accept string "json:\"-\" form:\"Accept,header,headerlist\"" }.Accept has modifier "headerlist" in form:"Accept,header,headerlist" tag, which requires a []string header field`)
+	}()
+	Default.Decode(r, nil, &bad)
+}
+
+func TestEncodeToRequest(t *testing.T) {
+	type input struct {
+		ID      string   `json:"-" form:"id,path,optional"`
+		Auth    string   `json:"-" form:"Authorization,header"`
+		Trace   string   `json:"-" form:"X-Trace,header,optional"`
+		Session string   `json:"-" form:"session,cookie"`
+		Flags   []string `json:"-" form:"flag,cookie"`
+		Name    string   `json:"name"`
+		Limit   int      `json:"limit"`
+	}
+	src := input{"42", "Bearer x", "", "s1", []string{"a", "b"}, "foo", 10}
+
+	h := make(http.Header)
+	ok(t, Default.EncodeToHeader(&src, h))
+	deepEqual(t, h, http.Header{"Authorization": {"Bearer x"}})
+
+	r, err := Default.EncodeToRequest(&src, "GET", "https://example.com/items/:id?v=1")
+	ok(t, err)
+	eq(t, r.URL.String(), "https://example.com/items/42?limit=10&name=foo&v=1")
+	eq(t, r.Header.Get("Cookie"), "session=s1; flag=a; flag=b")
+
+	var in input
+	ok(t, Default.Decode(r, nil, &in))
+	src.ID = ""
+	deepEqual(t, in, src)
+
+	r, err = Default.EncodeToRequest(&src, "POST", "https://example.com/items/:id")
+	ok(t, err)
+	eq(t, r.Header.Get("Content-Type"), "application/x-www-form-urlencoded")
+	body, _ := io.ReadAll(r.Body)
+	eq(t, string(body), "limit=10&name=foo")
+
+	_, err = Default.EncodeToRequest(&src, "GET", "https://example.com/items")
+	fails(t, err, ":id is not found in https://example.com/items")
+}
+
+func TestDecode_queryonly(t *testing.T) {
+	type input struct {
+		Name string `json:"name"`
+		Page int    `json:"page" form:",queryonly"`
+	}
+	var in input
+	r := httptest.NewRequest("POST", "https://example.com/subdir/?page=2", strings.NewReader(`{"name": "foo", "page": 5}`))
+	r.Header.Set("Content-Type", "application/json")
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in, input{"foo", 2})
+
+	in = input{}
+	r = httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{"name": "foo", "page": 5}`))
+	r.Header.Set("Content-Type", "application/json")
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in, input{"foo", 0})
+
+	in = input{}
+	r = httptest.NewRequest("POST", "https://example.com/subdir/?page=3", strings.NewReader(`name=bar&page=7`))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in, input{"bar", 3})
+
+	var in2 struct {
+		Foo   string `json:"foo"`
+		Token string `json:"token" form:",queryonly,required"`
+		Page  int    `json:"page" form:",queryonly,default=1"`
+	}
+	r = httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`foo=body&token=body&page=9`))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	fails(t, Default.Decode(r, nil, &in2), `[400] missing parameter token`)
+
+	r = httptest.NewRequest("POST", "https://example.com/subdir/?token=q", strings.NewReader(`foo=body&page=9`))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ok(t, Default.Decode(r, nil, &in2))
+	eq(t, in2.Token, "q")
+	eq(t, in2.Page, 1)
+}
+
+func TestDecode_sort(t *testing.T) {
+	var in struct {
+		Tags   []string     `json:"tags" form:",sort"`
+		IDs    []int64      `json:"ids" form:",sort,sep=comma"`
+		Peers  []netip.Addr `json:"peers" form:",sort"`
+		Others []string     `json:"others"`
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?tags=b&tags=c&tags=a&ids=10,9,-1&peers=10.0.0.2+10.0.0.10+10.0.0.1&others=b+a", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	deepEqual(t, in.Tags, []string{"a", "b", "c"})
+	deepEqual(t, in.IDs, []int64{-1, 9, 10})
+	deepEqual(t, in.Peers, []netip.Addr{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.10"), netip.MustParseAddr("10.0.0.2")})
+	deepEqual(t, in.Others, []string{"b", "a"})
+
+	r = httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{"tags": ["z", "y"]}`))
+	r.Header.Set("Content-Type", "application/json")
+	ok(t, Default.Decode(r, nil, &in))
+	deepEqual(t, in.Tags, []string{"y", "z"})
+}
+
+func TestDecode_bool_values(t *testing.T) {
+	type input struct {
+		A bool  `json:"a"`
+		B bool  `json:"b"`
+		C *bool `json:"c"`
+		D bool  `json:"d"`
+	}
+	conf := Default.Clone()
+	conf.BoolValues = map[string]bool{"si": true, "2": true, "no": false, "on": false}
+
+	var in input
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?a=si&b=true&c=2&d=on", nil)
+	ok(t, conf.Decode(r, nil, &in))
+	eq(t, in.A, true)
+	eq(t, in.B, true)
+	eq(t, *in.C, true)
+	eq(t, in.D, false)
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?a=si", nil)
+	fails(t, Default.Decode(r, nil, &in), `[400] invalid a: invalid bool value "si"`)
+}
+
+type testMethod int
+
+func TestDecode_method_enum(t *testing.T) {
+	conf := Default.Clone()
+	conf.RegisterIntEnum(reflect.TypeOf(testMethod(0)), map[string]int64{"GET": 1, "POST": 2})
+
+	type methodName string
+	var in struct {
+		Method testMethod `json:"-" form:",method"`
+		Name   methodName `json:"-" form:",method"`
+	}
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", nil)
+	ok(t, conf.Decode(r, nil, &in))
+	eq(t, in.Method, testMethod(2))
+	eq(t, in.Name, methodName("POST"))
+
+	r = httptest.NewRequest("DELETE", "https://example.com/subdir/", nil)
+	fails(t, conf.Decode(r, nil, &in), `[405] method DELETE not allowed`)
+}
+
+func TestDecode_trim(t *testing.T) {
+	type input struct {
+		Email string   `json:"email" form:",trim"`
+		Age   *int     `json:"age" form:",trim"`
+		Tags  []string `json:"tags" form:",trim,sep=comma"`
+		Raw   string   `json:"raw"`
+	}
+	var in input
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?email=+foo@bar.com+&age=+42+&tags=+a+,b+,+c&raw=+x+", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Email, "foo@bar.com")
+	eq(t, *in.Age, 42)
+	deepEqual(t, in.Tags, []string{"a", "b", "c"})
+	eq(t, in.Raw, " x ")
+
+	conf := Default.Clone()
+	conf.TrimAllValues = true
+	in = input{}
+	ok(t, conf.Decode(r, nil, &in))
+	eq(t, in.Raw, "x")
+}
+
+type testStatus int32
+
+func TestDecode_int_enum(t *testing.T) {
+	var in struct {
+		Status testStatus `json:"status"`
+	}
+	conf := Default.Clone()
+	conf.RegisterIntEnum(reflect.TypeOf(testStatus(0)), map[string]int64{"inactive": 0, "active": 1})
+
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?status=active", nil)
+	ok(t, conf.Decode(r, nil, &in))
+	eq(t, in.Status, testStatus(1))
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?status=1", nil)
+	fails(t, conf.Decode(r, nil, &in), `[400] invalid status: invalid value "1", expected one of: active, inactive`)
+
+	values := make(url.Values)
+	conf.EncodeToValues(&in, values)
+	eq(t, values.Encode(), "status=active")
+}
+
+func TestDecode_int_enum_case_insensitive(t *testing.T) {
+	var in struct {
+		Status   testStatus   `json:"status" form:",caseinsensitive"`
+		Statuses []testStatus `json:"statuses" form:",caseinsensitive"`
+		Strict   testStatus   `json:"strict"`
+	}
+	conf := Default.Clone()
+	conf.RegisterIntEnum(reflect.TypeOf(testStatus(0)), map[string]int64{"inactive": 0, "active": 1})
+
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?status=Active&statuses=ACTIVE+inactive", nil)
+	ok(t, conf.Decode(r, nil, &in))
+	eq(t, in.Status, testStatus(1))
+	deepEqual(t, in.Statuses, []testStatus{1, 0})
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?strict=Active", nil)
+	fails(t, conf.Decode(r, nil, &in), `[400] invalid strict: invalid value "Active", expected one of: active, inactive`)
+}
+
+func TestDecode_int_enum_numbers(t *testing.T) {
+	var in struct {
+		Status   testStatus   `json:"status" form:",enumnumbers"`
+		Statuses []testStatus `json:"statuses" form:",enumnumbers"`
+	}
+	conf := Default.Clone()
+	conf.RegisterIntEnum(reflect.TypeOf(testStatus(0)), map[string]int64{"inactive": 0, "active": 1})
+
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?status=1&statuses=active+0", nil)
+	ok(t, conf.Decode(r, nil, &in))
+	eq(t, in.Status, testStatus(1))
+	deepEqual(t, in.Statuses, []testStatus{1, 0})
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?status=inactive", nil)
+	ok(t, conf.Decode(r, nil, &in))
+	eq(t, in.Status, testStatus(0))
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?status=2", nil)
+	fails(t, conf.Decode(r, nil, &in), `[400] invalid status: invalid value "2", expected one of: active, inactive`)
+
+	values := make(url.Values)
+	conf.EncodeToValues(&in, values)
+	eq(t, values.Get("status"), "inactive")
+}
+
+func TestConfiguration_Merge(t *testing.T) {
+	type otherStatus int
+	base := Default.Clone()
+	base.JSONEnvelope = "data"
+	base.TimeLayouts = []string{"2006-01-02"}
+	base.RegisterIntEnum(reflect.TypeOf(testStatus(0)), map[string]int64{"inactive": 0, "active": 1})
+	base.RegisterIntEnum(reflect.TypeOf(otherStatus(0)), map[string]int64{"off": 0, "on": 1})
+
+	override := &Configuration{
+		DisallowUnknownFields: true,
+		TimeLayouts:           []string{time.RFC3339},
+	}
+	override.RegisterIntEnum(reflect.TypeOf(testStatus(0)), map[string]int64{"disabled": 0, "enabled": 1})
+
+	merged := base.Merge(override)
+	eq(t, merged.AllowJSON, true)
+	eq(t, merged.DisallowUnknownFields, true)
+	eq(t, merged.JSONEnvelope, "data")
+	deepEqual(t, merged.TimeLayouts, []string{time.RFC3339})
+	eq(t, base.DisallowUnknownFields, false)
+
+	var in struct {
+		Status testStatus  `json:"status"`
+		Other  otherStatus `json:"other"`
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?status=enabled&other=on", nil)
+	ok(t, merged.Decode(r, nil, &in))
+	eq(t, in.Status, testStatus(1))
+	eq(t, in.Other, otherStatus(1))
+	fails(t, base.Decode(r, nil, &in), `[400] invalid status: invalid value "enabled", expected one of: active, inactive`)
+}
+
+type testCents int64
+
+func (c *testCents) UnmarshalText(text []byte) error {
+	return errors.New("use the registered parser")
+}
+
+func TestConfiguration_RegisterType(t *testing.T) {
+	conf := Default.Clone()
+	conf.RegisterType(reflect.TypeOf(testCents(0)), func(s string) (reflect.Value, error) {
+		whole, frac, _ := strings.Cut(s, ".")
+		v, err := strconv.ParseInt(whole+(frac + "00")[:2], 10, 64)
+		if err != nil {
+			return reflect.Value{}, errors.New("invalid amount")
+		}
+		return reflect.ValueOf(testCents(v)), nil
+	}, func(v reflect.Value) (string, error) {
+		c := v.Int()
+		return fmt.Sprintf("%d.%02d", c/100, c%100), nil
+	})
+
+	var in struct {
+		Price   testCents   `json:"price"`
+		Tip     *testCents  `json:"tip"`
+		Refunds []testCents `json:"refunds"`
+	}
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?price=12.34&tip=1.5&refunds=1&refunds=2.05", nil)
+	ok(t, conf.Decode(r, nil, &in))
+	eq(t, in.Price, testCents(1234))
+	eq(t, *in.Tip, testCents(150))
+	deepEqual(t, in.Refunds, []testCents{100, 205})
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?price=abc", nil)
+	fails(t, conf.Decode(r, nil, &in), `[400] invalid price: invalid amount`)
+	fails(t, Default.Decode(r, nil, &in), `[400] invalid price: use the registered parser`)
+}
+
+type testEmail struct {
+	user, host string
+}
+
+func (e *testEmail) UnmarshalText(text []byte) error {
+	user, host, found := strings.Cut(string(text), "@")
+	if !found || user == "" || host == "" {
+		return errors.New("invalid email")
+	}
+	e.user, e.host = user, strings.ToLower(host)
+	return nil
+}
+
+func (e testEmail) MarshalText() ([]byte, error) {
+	return []byte(e.user + "@" + e.host), nil
+}
+
+func TestDecode_hidden_fields_via_setter(t *testing.T) {
+	type input struct {
+		Email testEmail `json:"email"`
+		note  string
+	}
+	var in input
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?email=bob@Example.com&note=x", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Email, testEmail{"bob", "example.com"})
+	eq(t, in.note, "")
+	values := make(url.Values)
+	Default.EncodeToValues(&in, values)
+	eq(t, values.Encode(), "email=bob%40example.com")
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?email=bob", nil)
+	fails(t, Default.Decode(r, nil, &in), "[400] invalid email: invalid email")
+}
+
+type testDigest struct {
+	sum [4]byte
+}
+
+func (d *testDigest) UnmarshalBinary(data []byte) error {
+	if len(data) != len(d.sum) {
+		return fmt.Errorf("digest must be %d bytes, got %d", len(d.sum), len(data))
+	}
+	copy(d.sum[:], data)
+	return nil
+}
+
+func (d *testDigest) MarshalBinary() ([]byte, error) {
+	return d.sum[:], nil
+}
+
+func TestDecode_binary_marshaler(t *testing.T) {
+	type input struct {
+		Digest  testDigest   `json:"digest"`
+		Parent  *testDigest  `json:"parent"`
+		History []testDigest `json:"history"`
+	}
+	var in input
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?digest=3q2-7w&parent=AQIDBA==&history=AAAAAA&history=_____w", nil)
+	ok(t, Default.Decode(r, nil, &in))
+	eq(t, in.Digest, testDigest{[4]byte{0xde, 0xad, 0xbe, 0xef}})
+	eq(t, *in.Parent, testDigest{[4]byte{1, 2, 3, 4}})
+	deepEqual(t, in.History, []testDigest{{}, {[4]byte{0xff, 0xff, 0xff, 0xff}}})
+
+	values := make(url.Values)
+	Default.EncodeToValues(&in, values)
+	eq(t, values.Get("digest"), "3q2-7w")
+	eq(t, values.Get("parent"), "AQIDBA")
+
+	values = make(url.Values)
+	Default.EncodeToValues(input{Digest: testDigest{[4]byte{1, 2, 3, 4}}}, values)
+	eq(t, values.Get("digest"), "AQIDBA")
+
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?digest=AQID", nil)
+	fails(t, Default.Decode(r, nil, &in), "[400] invalid digest: digest must be 4 bytes, got 3")
+	r = httptest.NewRequest("GET", "https://example.com/subdir/?digest=!!", nil)
+	fails(t, Default.Decode(r, nil, &in), "[400] invalid digest: illegal base64 data at input byte 0")
+}
+
+type testContextKey string
+
+func TestDecode_context(t *testing.T) {
+	type input struct {
+		Tenant  string `json:"-" form:",context=tenant"`
+		Subject *int   `json:"-" form:",context=subject"`
+		Name    string `json:"name"`
+	}
+	conf := Default.Clone()
+	conf.RegisterContextKey("tenant", testContextKey("tenant"))
+	conf.RegisterContextKey("subject", testContextKey("subject"))
+
+	subject := 42
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?name=foo", nil)
+	ctx := context.WithValue(r.Context(), testContextKey("tenant"), "acme")
+	ctx = context.WithValue(ctx, testContextKey("subject"), &subject)
+	var in input
+	ok(t, conf.Decode(r.WithContext(ctx), nil, &in))
+	deepEqual(t, in, input{"acme", &subject, "foo"})
+
+	in = input{}
+	ok(t, conf.Decode(r, nil, &in))
+	deepEqual(t, in, input{"", nil, "foo"})
+
+	func() {
+		defer func() {
+			e := recover()
+			if e == nil {
+				t.Fatal("** expected a panic")
+			}
+			eq(t, e.(error).Error(), `field httpform.input.Tenant has modifier "context" in form:",context=tenant" tag, but context key "tenant" is not registered via RegisterContextKey`)
+		}()
+		Default.Decode(r, nil, &in)
+	}()
+
+	defer func() {
+		e := recover()
+		if e == nil {
+			t.Fatal("** expected a panic")
+		}
+		eq(t, e.(error).Error(), `httpform: context value "tenant" is int, which cannot be assigned to field httpform.input.Tenant of type string`)
+	}()
+	ctx = context.WithValue(r.Context(), testContextKey("tenant"), 7)
+	conf.Decode(r.WithContext(ctx), nil, &in)
+}
+
+func TestConfiguration_OnUnknownField(t *testing.T) {
+	var in struct {
+		Foo  string `json:"foo"`
+		Auth string `json:"-" form:"X-API-Key,header,query=api_key,optional"`
+	}
+	var unknown []string
+	conf := Default.Clone()
+	conf.OnUnknownField = func(name string, r *http.Request) {
+		unknown = append(unknown, r.Method+" "+name)
+	}
+
+	r := httptest.NewRequest("GET", "https://example.com/subdir/?foo=x&bar=1&baz=2&api_key=k", nil)
+	ok(t, conf.Decode(r, nil, &in))
+	eq(t, in.Foo, "x")
+	sort.Strings(unknown)
+	deepEqual(t, unknown, []string{"GET bar", "GET baz"})
+
+	unknown = nil
+	r = httptest.NewRequest("POST", "https://example.com/subdir/?q=1", strings.NewReader(`{"foo": "y", "extra": {"foo": 1}}`))
+	r.Header.Set("Content-Type", "application/json")
+	ok(t, conf.Decode(r, nil, &in))
+	eq(t, in.Foo, "y")
+	sort.Strings(unknown)
+	deepEqual(t, unknown, []string{"POST extra", "POST q"})
+
+	unknown = nil
+	conf.DisallowUnknownFields = true
+	r = httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{"extra": 1}`))
+	r.Header.Set("Content-Type", "application/json")
+	fails(t, conf.Decode(r, nil, &in), `[400] JSON input: json: unknown field "extra"`)
+	deepEqual(t, unknown, []string(nil))
+}
+
+type phaseRecorder []Phase
+
+func (rec *phaseRecorder) ObserveDecode(r *http.Request, phase Phase, elapsed time.Duration) {
+	*rec = append(*rec, phase)
+}
+
+func TestDecode_observer(t *testing.T) {
+	var in struct {
+		Foo string `json:"foo"`
+	}
+	var rec phaseRecorder
+	conf := Default.Clone()
+	conf.Observer = &rec
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{ "foo": "bar" }`))
+	r.Header.Set("Content-Type", "application/json")
+	ok(t, conf.Decode(r, nil, &in))
+	deepEqual(t, []Phase(rec), []Phase{BodyPhase, FieldsPhase, ValidationPhase})
+
+	var in2 struct {
+		Foo string `json:"foo"`
+		Bar string `json:"bar" form:",requiredwith=foo"`
+	}
+	rec = nil
+	r = httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{ "foo": "bar" }`))
+	r.Header.Set("Content-Type", "application/json")
+	fails(t, conf.Decode(r, nil, &in2), "[400] missing parameter bar, required when foo is set")
+	deepEqual(t, []Phase(rec), []Phase{BodyPhase, FieldsPhase})
+}
+
+type fuzzInput struct {
+	Str    string    `json:"str"`
+	Int    int       `json:"int"`
+	Int8   int8      `json:"int8"`
+	Uint64 uint64    `json:"uint64"`
+	Float  float32   `json:"float"`
+	Bool   bool      `json:"bool"`
+	Ints   []int     `json:"ints" form:",sep=comma"`
+	Ptr    *int      `json:"ptr"`
+	Time   time.Time `json:"time"`
+	Header int       `json:"-" form:"X-Int,header,optional"`
+	Cookie string    `json:"-" form:"c,cookie"`
+	Raw    []byte    `json:"-" form:",rawbody"`
+	Full   any       `json:"-" form:",fullbody"`
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add("str=x&int=42", "", "", "")
+	f.Add("ints=1,2,3&bool=on", "application/x-www-form-urlencoded", "uint64=18446744073709551615&float=1.5", "7")
+	f.Add("", "application/json", `{"str": "x", "ints": [1, 2], "ptr": 3}`, "")
+	f.Add("_body=%7B%22int%22%3A1%7D", "", "", "")
+	f.Add("", "multipart/form-data; boundary=xxx", "--xxx\r\nContent-Disposition: form-data; name=\"str\"\r\n\r\nfoo\r\n--xxx--\r\n", "")
+	f.Add("", "multipart/form-data; boundary=xxx", "--yyy\r\n", "")
+	f.Add("time=2023-01-01T00:00:00Z&int8=300", "", "", "x")
+	f.Fuzz(func(t *testing.T, query, ctype, body, header string) {
+		var in fuzzInput
+		r := httptest.NewRequest("POST", "https://example.com/", strings.NewReader(body))
+		r.URL.RawQuery = query
+		if ctype != "" {
+			r.Header.Set("Content-Type", ctype)
+		}
+		r.Header.Set("X-Int", header)
+		r.Header.Set("Cookie", "c="+header)
+		_ = Default.Decode(r, nil, &in)
+	})
+}
+
+func FuzzPickParser(f *testing.F) {
+	types := []reflect.Type{
+		reflect.TypeOf(""),
+		reflect.TypeOf(false),
+		reflect.TypeOf(int(0)),
+		reflect.TypeOf(int8(0)),
+		reflect.TypeOf(uint16(0)),
+		reflect.TypeOf(uint64(0)),
+		reflect.TypeOf(uintptr(0)),
+		reflect.TypeOf(float32(0)),
+		reflect.TypeOf(float64(0)),
+		reflect.TypeOf([]int(nil)),
+		reflect.TypeOf((*int)(nil)),
+		reflect.TypeOf(time.Time{}),
+		reflect.TypeOf([2]int{}),
+		reflect.TypeOf(complex64(0)),
+	}
+	f.Add("")
+	f.Add("42")
+	f.Add("-1")
+	f.Add("18446744073709551615")
+	f.Add("1e400")
+	f.Add("on")
+	f.Add("1 2 3")
+	f.Add("2023-01-01T00:00:00Z")
+	f.Fuzz(func(t *testing.T, s string) {
+		for _, typ := range types {
+			ropt := fieldStringRepresenationOpts{sep: ' '}
+			parse, stringify := Default.pickParser(typ, ropt), Default.pickStringer(typ, ropt)
+			if parse == nil || stringify == nil {
+				continue
+			}
+			v, err := parse(s)
+			if err != nil {
+				continue
+			}
+			// stringified values must parse back to themselves
+			s1, err := stringify(v)
+			if err != nil {
+				continue
+			}
+			v2, err := parse(s1)
+			if err != nil {
+				t.Fatalf("%v: %q parsed, stringified to %q, which fails to parse: %v", typ, s, s1, err)
+			}
+			s2, err := stringify(v2)
+			if err != nil {
+				t.Fatalf("%v: %q failed to stringify: %v", typ, s1, err)
+			}
+			if s1 != s2 {
+				t.Fatalf("%v: %q stringified to %q, which round-trips to %q", typ, s, s1, s2)
+			}
+		}
+	})
+}
+
+func BenchmarkDecode_headers_only(b *testing.B) {
+	r := httptest.NewRequest("POST", "https://example.com/subdir/?foo=bar", strings.NewReader(`{"foo": "bar"}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Authorization", "Bearer token")
+	r.Header.Set("X-Request-Id", "123")
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var in struct {
+			Auth      string `form:"Authorization,header" json:"-"`
+			RequestID string `form:"X-Request-Id,header" json:"-"`
+		}
+		if err := Default.Decode(r, nil, &in); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if r.Form != nil {
+		b.Fatal("form parsed")
+	}
+}
+
+func ok(t testing.TB, err error) {
+	if err != nil {
+		t.Helper()
+		t.Fatalf("** %v", err)
+	}
+}
+
+type countingJSONCodec struct {
+	decoders, strict int
+}
+
+type countingJSONDecoder struct {
+	*json.Decoder
+	codec *countingJSONCodec
+}
+
+func (c *countingJSONCodec) NewDecoder(r io.Reader) JSONDecoder {
+	c.decoders++
+	return countingJSONDecoder{json.NewDecoder(r), c}
+}
+
+func (d countingJSONDecoder) DisallowUnknownFields() {
+	d.codec.strict++
+	d.Decoder.DisallowUnknownFields()
+}
+
+func TestConfiguration_JSONCodec(t *testing.T) {
+	var in struct {
+		Foo  string `json:"foo"`
+		Body any    `form:",fullbody" json:"-"`
+	}
+	codec := &countingJSONCodec{}
+	conf := Default.Strict()
+	conf.JSONCodec = codec
+	r := httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{"foo": "bar"}`))
+	r.Header.Set("Content-Type", "application/json")
+	ok(t, conf.Decode(r, nil, &in))
+	eq(t, in.Foo, "bar")
+	deepEqual(t, in.Body, map[string]any{"foo": "bar"})
+	eq(t, codec.decoders, 2) // struct and fullbody
+	eq(t, codec.strict, 1)
+
+	r = httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{"boz": 1}`))
+	r.Header.Set("Content-Type", "application/json")
+	fails(t, conf.Decode(r, nil, &in), `[400] JSON input: json: unknown field "boz"`)
+
+	var in2 struct {
+		Name string `json:"name" form:",required"`
+	}
+	codec = &countingJSONCodec{}
+	conf = Default.Clone()
+	conf.JSONCodec = codec
+	conf.JSONEnvelope = "data"
+	r = httptest.NewRequest("POST", "https://example.com/subdir/", strings.NewReader(`{"data": {"name": "foo"}}`))
+	r.Header.Set("Content-Type", "application/json")
+	ok(t, conf.Decode(r, nil, &in2))
+	eq(t, in2.Name, "foo")
+	eq(t, codec.decoders, 3) // envelope, struct and keys
 }
 
 func eq[T comparable](t testing.TB, a, e T) {
@@ -0,0 +1,41 @@
+package httpform
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// JSONCodec creates the decoders used for JSON bodies, so that a faster
+// implementation like jsoniter, go-json or sonic can stand in for
+// encoding/json, which is used when Configuration.JSONCodec is nil.
+//
+// The codec handles every JSON parse, including the extra ones for
+// JSONEnvelope, LenientJSONBools, batches and finding out which keys
+// a body has. Only LenientJSONBools re-encodes the rewritten body with
+// encoding/json, and only when it has 0 or 1 to rewrite.
+//
+// Error messages for bad JSON are the codec's own; field type errors are
+// only rephrased for *json.UnmarshalTypeError.
+type JSONCodec interface {
+	NewDecoder(r io.Reader) JSONDecoder
+}
+
+// JSONDecoder is the subset of *json.Decoder that httpform needs.
+type JSONDecoder interface {
+	Decode(v any) error
+	DisallowUnknownFields()
+}
+
+func (conf *Configuration) newJSONDecoder(r io.Reader) JSONDecoder {
+	if conf.JSONCodec == nil {
+		return json.NewDecoder(r)
+	}
+	return conf.JSONCodec.NewDecoder(r)
+}
+
+// unmarshalJSON is json.Unmarshal via JSONCodec, minus the check for
+// trailing data.
+func (conf *Configuration) unmarshalJSON(data []byte, v any) error {
+	return conf.newJSONDecoder(bytes.NewReader(data)).Decode(v)
+}
@@ -0,0 +1,46 @@
+package httpform
+
+import (
+	"net/http"
+	"time"
+)
+
+// Phase is a stage of decoding reported to an Observer.
+type Phase int
+
+const (
+	// BodyPhase covers reading the request body and parsing it as JSON or form data.
+	BodyPhase Phase = iota
+	// FieldsPhase covers populating fields from form values, path params, headers, cookies and request metadata.
+	FieldsPhase
+	// ValidationPhase covers conditional field checks, the Validator and AfterDecode hooks.
+	ValidationPhase
+)
+
+var _phases = []string{"body", "fields", "validation"}
+
+func (p Phase) String() string {
+	return _phases[p]
+}
+
+// Observer receives the time spent in each phase of decoding, e.g. to feed
+// Prometheus histograms. Phases that fail are not reported.
+type Observer interface {
+	ObserveDecode(r *http.Request, phase Phase, elapsed time.Duration)
+}
+
+func (conf *Configuration) observeStart() time.Time {
+	if conf.Observer == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+func (conf *Configuration) observe(r *http.Request, phase Phase, since time.Time) time.Time {
+	if conf.Observer == nil {
+		return since
+	}
+	now := time.Now()
+	conf.Observer.ObserveDecode(r, phase, now.Sub(since))
+	return now
+}
@@ -0,0 +1,129 @@
+package httpform
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+)
+
+// DecodeOptions overrides Configuration settings for a single DecodeWith call.
+// The zero value of each field means “use the Configuration setting”, and
+// a nil *DecodeOptions leaves the Configuration in charge entirely.
+type DecodeOptions struct {
+	// DisallowUnknownFields, if non-nil, replaces both
+	// Configuration.DisallowUnknownFields and AllowUnknownFieldsHeader.
+	DisallowUnknownFields *bool
+
+	// MaxMultipartMemory, if positive, replaces Configuration.MaxMultipartMemory.
+	MaxMultipartMemory int64
+
+	// JSONEnvelope, if non-empty, replaces Configuration.JSONEnvelope.
+	JSONEnvelope string
+
+	// DisableJSONBodyFallback ignores Configuration.JSONBodyFallbackParam.
+	DisableJSONBodyFallback bool
+
+	// OnlySources, if non-zero, limits decoding to the given parts of the
+	// request; fields sourced from other parts are left untouched. Without
+	// FormSource, the body is neither read nor closed, so that middleware
+	// can decode headers early and leave the body for a later stage.
+	OnlySources SourceMask
+
+	// Current, if non-nil, points to a struct of the destination type holding
+	// the current state of the entity being updated, e.g. by a PATCH handler.
+	// Fields with the immutable modifier are then copied from Current when
+	// the request leaves them unset (zero), and decoding fails with 400 when
+	// the request sets them to a different value.
+	Current any
+}
+
+// SourceMask is a set of request parts to decode, see DecodeOptions.OnlySources.
+type SourceMask uint
+
+const (
+	PathSource    SourceMask = 1 << iota // path params
+	FormSource                           // query string and body, incl. rawbody, fullbody and files
+	HeaderSource                         // headers, incl. http.Header, range and dispositionfilename fields
+	CookieSource                         // cookies
+	RequestSource                        // request metadata: *http.Request, URL, query values, raw query, method, proto, issave, remoteip
+
+	AllSources = PathSource | FormSource | HeaderSource | CookieSource | RequestSource
+)
+
+var noOptions = &DecodeOptions{}
+
+// DecodeResult describes what DecodeWith has done, for logging and debugging.
+type DecodeResult struct {
+	// ContentType is the MIME type the body was decoded as, or "" if the
+	// body wasn't read. A JSON body passed via JSONBodyFallbackParam counts
+	// as application/json.
+	ContentType string
+
+	// Fields lists the sorted names of the fields set from the request.
+	// Fields set from the JSON body are detected by their top-level keys,
+	// so an explicit null or zero counts, while defaults don't.
+	Fields []string
+
+	// Warnings describes non-fatal problems, like ignored unknown params.
+	Warnings []string
+
+	// RoutePattern is the http.ServeMux pattern that matched the request
+	// (Go 1.23+), or "" if there is none.
+	RoutePattern string
+}
+
+// Has reports whether the request has provided the named field, e.g. to
+// only update fields present in a PATCH request without resorting to
+// pointers everywhere.
+func (res *DecodeResult) Has(name string) bool {
+	i := sort.SearchStrings(res.Fields, name)
+	return i < len(res.Fields) && res.Fields[i] == name
+}
+
+func (res *DecodeResult) populated(name string) {
+	if res != nil {
+		res.Fields = append(res.Fields, name)
+	}
+}
+
+func (res *DecodeResult) warn(format string, args ...any) {
+	if res != nil {
+		res.Warnings = append(res.Warnings, fmt.Sprintf(format, args...))
+	}
+}
+
+// noteJSONKeys records the top-level keys of a JSON object body as populated
+// fields, and unknown ones as warnings.
+func (res *DecodeResult) noteJSONKeys(sm *structMeta, obj map[string]json.RawMessage) {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if sm.isJSONField(k) {
+			res.populated(k)
+		} else {
+			res.warn("unknown field %q in JSON body", k)
+		}
+	}
+}
+
+func (res *DecodeResult) finish(r *http.Request) {
+	sort.Strings(res.Fields)
+	n := 0
+	for i, name := range res.Fields {
+		if i == 0 || name != res.Fields[n-1] {
+			res.Fields[n] = name
+			n++
+		}
+	}
+	res.Fields = res.Fields[:n]
+
+	// http.Request.Pattern is Go 1.23+, and we still support older versions
+	if f := reflect.ValueOf(r).Elem().FieldByName("Pattern"); f.IsValid() && f.Kind() == reflect.String {
+		res.RoutePattern = f.String()
+	}
+}
@@ -2,9 +2,18 @@ package httpform
 
 import (
 	"encoding"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"math/big"
+	"net/url"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 type ParserFunc func(s string) (reflect.Value, error)
@@ -12,13 +21,148 @@ type StringerFunc func(v reflect.Value) (string, error)
 
 var textMarshaller = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
 var textUnmarshaller = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+var binaryMarshaller = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+var binaryUnmarshaller = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+var ratPtrType = reflect.TypeOf((*big.Rat)(nil))
+var regexpPtrType = reflect.TypeOf((*regexp.Regexp)(nil))
+var durationType = reflect.TypeOf(time.Duration(0))
+var timeType = reflect.TypeOf(time.Time{})
+var timePtrType = reflect.TypeOf((*time.Time)(nil))
 
 type fieldStringRepresenationOpts struct {
-	sep rune
+	sep             rune
+	caseInsensitive bool
+	maxItems        int
+	decimalComma    bool // opt-in per field, since comma is also a list separator
+	enumNumbers     bool
+	units           map[string]float64 // registered units, for fields with the units modifier
+	trim            bool
 }
 
-func pickParser(typ reflect.Type, ropt fieldStringRepresenationOpts) ParserFunc {
-	if typ.AssignableTo(textUnmarshaller) {
+func tooManyItems(max int) error {
+	return fmt.Errorf("more than %d items", max)
+}
+
+type customType struct {
+	parse     ParserFunc
+	stringify StringerFunc
+}
+
+// RegisterType makes fields of the given type parse and stringify using the
+// given functions, e.g. for third-party types that don't implement
+// encoding.TextUnmarshaler. Registered types take precedence over everything
+// else, including TextUnmarshaler and RegisterIntEnum. The functions also
+// apply to slice items and to pointers, unless a pointer type is registered
+// separately. parse must return a value of type typ.
+//
+// Call RegisterType during initialization, before decoding anything with
+// this Configuration.
+func (conf *Configuration) RegisterType(typ reflect.Type, parse ParserFunc, stringify StringerFunc) {
+	conf.customTypes = mergeMaps(conf.customTypes, map[reflect.Type]customType{typ: {parse, stringify}})
+	conf.resetStructCache()
+}
+
+// RegisterUnit makes fields with the units modifier accept numbers with
+// the given suffix, multiplied by multiplier to get the base quantity, e.g.
+// RegisterUnit("KB", 1024) turns size=10KB into 10240. Numbers without
+// a suffix are taken as is. The longest matching suffix wins, and suffixes
+// are case-sensitive, so register both "km" and "KM" if needed.
+//
+// Call RegisterUnit during initialization, before decoding anything with
+// this Configuration.
+func (conf *Configuration) RegisterUnit(suffix string, multiplier float64) {
+	conf.units = mergeMaps(conf.units, map[string]float64{suffix: multiplier})
+	conf.resetStructCache()
+}
+
+func (conf *Configuration) pickParser(typ reflect.Type, ropt fieldStringRepresenationOpts) ParserFunc {
+	if ropt.trim && typ.Kind() != reflect.Slice { // slices trim each item instead
+		ropt.trim = false
+		return trimmedParser(conf.pickParser(typ, ropt))
+	}
+	if custom, found := conf.customTypes[typ]; found {
+		return custom.parse
+	}
+	if ropt.units != nil && isNumberKind(typ.Kind()) {
+		units := ropt.units
+		ropt.units = nil
+		return unitsParser(conf.pickParser(typ, ropt), units, isIntegerKind(typ.Kind()))
+	}
+	if typ.Kind() == reflect.Pointer {
+		// *T would otherwise hit T's UnmarshalText before the registered T
+		if _, found := conf.customTypes[typ.Elem()]; found {
+			return conf.pointerParser(typ, ropt)
+		}
+	}
+	if enum := conf.intEnums[typ]; enum != nil {
+		return enum.parser(typ, ropt.caseInsensitive, ropt.enumNumbers)
+	}
+	if typ == ratPtrType {
+		return func(s string) (reflect.Value, error) {
+			if s == "" {
+				return reflect.Zero(typ), nil
+			}
+			v, ok := new(big.Rat).SetString(s)
+			if !ok {
+				return reflect.Value{}, fmt.Errorf("invalid fraction %q", s)
+			}
+			return reflect.ValueOf(v), nil
+		}
+	}
+	if typ == durationType {
+		return func(s string) (reflect.Value, error) {
+			if s == "" {
+				return reflect.Zero(typ), nil
+			}
+			v, err := time.ParseDuration(s)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			return reflect.ValueOf(v), nil
+		}
+	}
+	if typ == regexpPtrType {
+		return func(s string) (reflect.Value, error) {
+			if s == "" {
+				return reflect.Zero(typ), nil
+			}
+			re, err := regexp.Compile(s)
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("invalid pattern: %w", err)
+			}
+			return reflect.ValueOf(re), nil
+		}
+	}
+	if len(conf.TimeLayouts) > 0 && (typ == timeType || typ == timePtrType) {
+		layouts := conf.TimeLayouts
+		return func(s string) (reflect.Value, error) {
+			if s == "" {
+				return reflect.Zero(typ), nil
+			}
+			for _, layout := range layouts {
+				if t, err := time.Parse(layout, s); err == nil {
+					if typ == timePtrType {
+						return reflect.ValueOf(&t), nil
+					}
+					return reflect.ValueOf(t), nil
+				}
+			}
+			return reflect.Value{}, fmt.Errorf("invalid time %q, expected one of: %s", s, strings.Join(layouts, ", "))
+		}
+	}
+	if typ.Kind() == reflect.Pointer && typ.AssignableTo(textUnmarshaller) {
+		return func(s string) (reflect.Value, error) {
+			if s == "" {
+				return reflect.Zero(typ), nil
+			}
+			v := reflect.New(typ.Elem())
+			err := v.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			return v, nil
+		}
+	} else if typ.AssignableTo(textUnmarshaller) {
 		return func(s string) (reflect.Value, error) {
 			v := reflect.New(typ).Elem()
 			err := v.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
@@ -37,22 +181,31 @@ func pickParser(typ reflect.Type, ropt fieldStringRepresenationOpts) ParserFunc
 			return v.Elem(), nil
 		}
 	}
+	if isBinaryFallbackType(typ) && reflect.PointerTo(typ).AssignableTo(binaryUnmarshaller) {
+		return binaryParser(typ)
+	}
 	switch typ.Kind() {
 	case reflect.String:
 		return func(s string) (reflect.Value, error) {
 			return reflect.ValueOf(s).Convert(typ), nil
 		}
 	case reflect.Bool:
+		boolValues := conf.BoolValues
 		return func(s string) (reflect.Value, error) {
 			if s == "" {
 				return reflect.ValueOf(false).Convert(typ), nil
 			}
+			if v, found := boolValues[s]; found {
+				return reflect.ValueOf(v).Convert(typ), nil
+			}
 			v, err := ParseBool(s)
 			if err != nil {
 				return reflect.Value{}, err
 			}
 			return reflect.ValueOf(v).Convert(typ), nil
 		}
+	// Integers are always base 10, so that IDs like 007 or 010 arrive intact
+	// rather than being read as octal, like base 0 in strconv would do.
 	case reflect.Int:
 		return func(s string) (reflect.Value, error) {
 			if s == "" {
@@ -172,13 +325,16 @@ func pickParser(typ reflect.Type, ropt fieldStringRepresenationOpts) ParserFunc
 			if err != nil {
 				return reflect.Value{}, err
 			}
-			return reflect.ValueOf(int64(v)).Convert(typ), nil
+			return reflect.ValueOf(v).Convert(typ), nil
 		}
 	case reflect.Float32:
 		return func(s string) (reflect.Value, error) {
 			if s == "" {
 				return reflect.ValueOf(0).Convert(typ), nil
 			}
+			if ropt.decimalComma {
+				s = decimalCommaToDot(s)
+			}
 			v, err := strconv.ParseFloat(s, 32)
 			if err != nil {
 				return reflect.Value{}, err
@@ -190,6 +346,9 @@ func pickParser(typ reflect.Type, ropt fieldStringRepresenationOpts) ParserFunc
 			if s == "" {
 				return reflect.ValueOf(0).Convert(typ), nil
 			}
+			if ropt.decimalComma {
+				s = decimalCommaToDot(s)
+			}
 			v, err := strconv.ParseFloat(s, 64)
 			if err != nil {
 				return reflect.Value{}, err
@@ -197,14 +356,17 @@ func pickParser(typ reflect.Type, ropt fieldStringRepresenationOpts) ParserFunc
 			return reflect.ValueOf((v)).Convert(typ), nil
 		}
 	case reflect.Slice:
-		child := pickParser(typ.Elem(), fieldStringRepresenationOpts{})
-		// TODO: use ropt.sep
+		child := conf.pickParser(typ.Elem(), fieldStringRepresenationOpts{caseInsensitive: ropt.caseInsensitive, decimalComma: ropt.decimalComma, enumNumbers: ropt.enumNumbers, units: ropt.units, trim: ropt.trim})
 		return func(s string) (reflect.Value, error) {
 			if s == "" {
 				return reflect.Zero(typ), nil
 			}
 
-			itemStrs := fieldsSep(s, ropt.sep)
+			// empty items of []*T become nil pointers
+			itemStrs := fieldsSep(s, ropt.sep, ropt.maxItems, typ.Elem().Kind() == reflect.Pointer)
+			if ropt.maxItems > 0 && len(itemStrs) > ropt.maxItems {
+				return reflect.Value{}, tooManyItems(ropt.maxItems)
+			}
 			sliceVal := reflect.MakeSlice(typ, 0, len(itemStrs))
 			for _, itemStr := range itemStrs {
 				v, err := child(itemStr)
@@ -216,29 +378,124 @@ func pickParser(typ reflect.Type, ropt fieldStringRepresenationOpts) ParserFunc
 
 			return sliceVal, nil
 		}
-	case reflect.Pointer:
-		child := pickParser(typ.Elem(), ropt)
+	case reflect.Array:
+		child := conf.pickParser(typ.Elem(), fieldStringRepresenationOpts{caseInsensitive: ropt.caseInsensitive, decimalComma: ropt.decimalComma, enumNumbers: ropt.enumNumbers, units: ropt.units, trim: ropt.trim})
+		if child == nil {
+			return nil
+		}
 		return func(s string) (reflect.Value, error) {
+			arrayVal := reflect.New(typ).Elem()
 			if s == "" {
-				return reflect.Zero(typ), nil
+				return arrayVal, nil
+			}
+			itemStrs := fieldsSep(s, ropt.sep, 0, typ.Elem().Kind() == reflect.Pointer)
+			if len(itemStrs) != typ.Len() {
+				return reflect.Value{}, fmt.Errorf("expected %d items, got %d", typ.Len(), len(itemStrs))
+			}
+			for i, itemStr := range itemStrs {
+				v, err := child(itemStr)
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				arrayVal.Index(i).Set(v)
+			}
+			return arrayVal, nil
+		}
+	case reflect.Struct:
+		if !isNullStruct(typ) {
+			return nil
+		}
+		child := conf.pickParser(typ.Field(0).Type, ropt)
+		if child == nil {
+			return nil
+		}
+		return func(s string) (reflect.Value, error) {
+			nv := reflect.New(typ).Elem()
+			if s == "" {
+				return nv, nil
 			}
 			v, err := child(s)
 			if err != nil {
 				return reflect.Value{}, err
 			}
-
-			pv := reflect.New(typ.Elem())
-			pv.Elem().Set(v)
-			return pv, err
+			nv.Field(0).Set(v)
+			nv.Field(1).SetBool(true)
+			return nv, nil
 		}
+	case reflect.Pointer:
+		return conf.pointerParser(typ, ropt)
 	default:
 		return nil
 	}
 }
 
-func pickStringer(typ reflect.Type, ropt fieldStringRepresenationOpts) StringerFunc {
+func (conf *Configuration) pointerParser(typ reflect.Type, ropt fieldStringRepresenationOpts) ParserFunc {
+	child := conf.pickParser(typ.Elem(), ropt)
+	return func(s string) (reflect.Value, error) {
+		if s == "" {
+			return reflect.Zero(typ), nil
+		}
+		v, err := child(s)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+
+		pv := reflect.New(typ.Elem())
+		pv.Elem().Set(v)
+		return pv, err
+	}
+}
+
+func (conf *Configuration) pickStringer(typ reflect.Type, ropt fieldStringRepresenationOpts) StringerFunc {
+	if custom, found := conf.customTypes[typ]; found {
+		return custom.stringify
+	}
+	if typ.Kind() == reflect.Pointer {
+		if _, found := conf.customTypes[typ.Elem()]; found {
+			return conf.pointerStringer(typ, ropt)
+		}
+	}
+	if enum := conf.intEnums[typ]; enum != nil {
+		return enum.stringer()
+	}
+	if typ == ratPtrType {
+		return func(v reflect.Value) (string, error) {
+			if v.IsNil() {
+				return "", nil
+			}
+			return v.Interface().(*big.Rat).RatString(), nil
+		}
+	}
+	if typ == durationType {
+		return func(v reflect.Value) (string, error) {
+			return v.Interface().(time.Duration).String(), nil
+		}
+	}
+	if typ == regexpPtrType {
+		return func(v reflect.Value) (string, error) {
+			if v.IsNil() {
+				return "", nil
+			}
+			return v.Interface().(*regexp.Regexp).String(), nil
+		}
+	}
+	if len(conf.TimeLayouts) > 0 && (typ == timeType || typ == timePtrType) {
+		layout := conf.TimeLayouts[0]
+		return func(v reflect.Value) (string, error) {
+			if typ == timePtrType {
+				if v.IsNil() {
+					return "", nil
+				}
+				v = v.Elem()
+			}
+			return v.Interface().(time.Time).Format(layout), nil
+		}
+	}
 	if typ.AssignableTo(textMarshaller) {
 		return func(v reflect.Value) (string, error) {
+			if typ.Kind() == reflect.Pointer && v.IsNil() {
+				return "", nil
+			}
 			raw, err := v.Interface().(encoding.TextMarshaler).MarshalText()
 			if err != nil {
 				return "", err
@@ -255,6 +512,9 @@ func pickStringer(typ reflect.Type, ropt fieldStringRepresenationOpts) StringerF
 			return string(raw), nil
 		}
 	}
+	if isBinaryFallbackType(typ) && (typ.AssignableTo(binaryMarshaller) || reflect.PointerTo(typ).AssignableTo(binaryMarshaller)) {
+		return binaryStringer(typ)
+	}
 	switch typ.Kind() {
 	case reflect.String:
 		return func(v reflect.Value) (string, error) {
@@ -281,7 +541,7 @@ func pickStringer(typ reflect.Type, ropt fieldStringRepresenationOpts) StringerF
 			return strconv.FormatFloat(v.Float(), 'g', -1, 64), nil
 		}
 	case reflect.Slice:
-		child := pickStringer(typ.Elem(), fieldStringRepresenationOpts{})
+		child := conf.pickStringer(typ.Elem(), fieldStringRepresenationOpts{})
 		return func(v reflect.Value) (string, error) {
 			if v.IsNil() || v.Len() == 0 {
 				return "", nil
@@ -300,27 +560,344 @@ func pickStringer(typ reflect.Type, ropt fieldStringRepresenationOpts) StringerF
 			}
 			return buf.String(), nil
 		}
-	case reflect.Pointer:
-		child := pickStringer(typ.Elem(), ropt)
+	case reflect.Array:
+		child := conf.pickStringer(typ.Elem(), fieldStringRepresenationOpts{})
+		if child == nil {
+			return nil
+		}
 		return func(v reflect.Value) (string, error) {
-			if v.IsNil() {
+			var buf strings.Builder
+			for i, n := 0, v.Len(); i < n; i++ {
+				if i > 0 {
+					buf.WriteRune(ropt.sep)
+				}
+				itemStr, err := child(v.Index(i))
+				if err != nil {
+					return "", err
+				}
+				buf.WriteString(itemStr)
+			}
+			return buf.String(), nil
+		}
+	case reflect.Struct:
+		if !isNullStruct(typ) {
+			return nil
+		}
+		child := conf.pickStringer(typ.Field(0).Type, ropt)
+		if child == nil {
+			return nil
+		}
+		return func(v reflect.Value) (string, error) {
+			if !v.Field(1).Bool() {
 				return "", nil
 			}
-			return child(v.Elem())
+			return child(v.Field(0))
 		}
+	case reflect.Pointer:
+		return conf.pointerStringer(typ, ropt)
 	default:
 		return nil
 	}
 }
 
-func fieldsSep(str string, sep rune) []string {
-	if sep == ' ' {
-		return strings.Fields(str)
-	} else {
-		items := strings.FieldsFunc(str, func(r rune) bool { return r == sep })
-		return filterInPlace(items, func(item string) (string, bool) {
+func (conf *Configuration) pointerStringer(typ reflect.Type, ropt fieldStringRepresenationOpts) StringerFunc {
+	child := conf.pickStringer(typ.Elem(), ropt)
+	return func(v reflect.Value) (string, error) {
+		if v.IsNil() {
+			return "", nil
+		}
+		return child(v.Elem())
+	}
+}
+
+// nestedFormParser parses an urlencoded string into a struct or a pointer to one,
+// for legacy clients that send a form as a single value of another form.
+func (conf *Configuration) nestedFormParser(typ reflect.Type) ParserFunc {
+	structTyp := typ
+	if typ.Kind() == reflect.Pointer {
+		structTyp = typ.Elem()
+	}
+	return func(s string) (reflect.Value, error) {
+		if s == "" && typ != structTyp {
+			return reflect.Zero(typ), nil
+		}
+		values, err := url.ParseQuery(s)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		ptrVal := reflect.New(structTyp)
+		structVal := ptrVal.Elem()
+		sm := conf.lookupStruct(structTyp)
+		for k, vv := range values {
+			err := setVals(structVal, sm, formSrc, k, vv)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+		}
+		if typ != structTyp {
+			return ptrVal, nil
+		}
+		return structVal, nil
+	}
+}
+
+func (conf *Configuration) nestedFormStringer(typ reflect.Type) StringerFunc {
+	return func(v reflect.Value) (string, error) {
+		if typ.Kind() == reflect.Pointer && v.IsNil() {
+			return "", nil
+		}
+		values := make(url.Values)
+		conf.EncodeToValues(v.Interface(), values)
+		return values.Encode(), nil
+	}
+}
+
+// isNullStruct detects sql.NullString-like types: struct { X T; Valid bool }.
+func isNullStruct(typ reflect.Type) bool {
+	if typ.Kind() != reflect.Struct || typ.NumField() != 2 {
+		return false
+	}
+	value, valid := typ.Field(0), typ.Field(1)
+	return value.IsExported() && valid.Name == "Valid" && valid.Type.Kind() == reflect.Bool
+}
+
+// fieldsSep splits str by sep, trimming spaces and dropping empty items,
+// unless keepEmpty is set and sep isn't a space, in which case empty items
+// between separators are kept. With limit > 0, it stops after limit+1 items,
+// which is enough to tell that there are too many without splitting a huge
+// input entirely.
+func fieldsSep(str string, sep rune, limit int, keepEmpty bool) []string {
+	var items []string
+	for str != "" && (limit <= 0 || len(items) <= limit) {
+		var item string
+		if sep == ' ' {
+			str = strings.TrimLeftFunc(str, unicode.IsSpace)
+			if str == "" {
+				break
+			}
+			item, str = cutFunc(str, unicode.IsSpace)
+		} else {
+			item, str = cutFunc(str, func(r rune) bool { return r == sep })
+			if len(str) > 0 {
+				str = str[utf8.RuneLen(sep):]
+			}
 			item = strings.TrimSpace(item)
-			return item, item != ""
-		})
+			if item == "" && !keepEmpty {
+				continue
+			}
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// cutFunc splits s before the first rune satisfying f.
+func cutFunc(s string, f func(rune) bool) (before, after string) {
+	if i := strings.IndexFunc(s, f); i >= 0 {
+		return s[:i], s[i:]
+	}
+	return s, ""
+}
+
+func isIntegerKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uintptr, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	default:
+		return false
+	}
+}
+
+// decimalCommaToDot turns 3,14 into 3.14 by replacing the last comma.
+func decimalCommaToDot(s string) string {
+	if i := strings.LastIndexByte(s, ','); i >= 0 {
+		return s[:i] + "." + s[i+1:]
+	}
+	return s
+}
+
+func isNumberKind(k reflect.Kind) bool {
+	return isIntegerKind(k) || k == reflect.Float32 || k == reflect.Float64
+}
+
+// unitsParser accepts numbers with a registered unit suffix, like 10MB,
+// and passes the base quantity on to parse.
+func unitsParser(parse ParserFunc, units map[string]float64, isInteger bool) ParserFunc {
+	return func(s string) (reflect.Value, error) {
+		num, multiplier := s, 1.0
+		var matched string
+		for suffix, m := range units {
+			if len(suffix) > len(matched) && len(suffix) < len(s) && strings.HasSuffix(s, suffix) {
+				matched, num, multiplier = suffix, s[:len(s)-len(suffix)], m
+			}
+		}
+		if matched == "" {
+			return parse(s)
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("invalid quantity %q", s)
+		}
+		f *= multiplier
+		if isInteger && f != math.Trunc(f) {
+			return reflect.Value{}, fmt.Errorf("%q is not a whole number of base units", s)
+		}
+		return parse(strconv.FormatFloat(f, 'f', -1, 64))
+	}
+}
+
+// trimmedParser strips leading and trailing whitespace, e.g. from autofill.
+func trimmedParser(parse ParserFunc) ParserFunc {
+	if parse == nil {
+		return nil
+	}
+	return func(s string) (reflect.Value, error) {
+		return parse(strings.TrimSpace(s))
+	}
+}
+
+// isBinaryFallbackType reports whether typ may be represented as base64 of
+// its MarshalBinary output. Only structs and arrays that we cannot represent
+// otherwise qualify, so that e.g. an int type with binary methods stays a number.
+func isBinaryFallbackType(typ reflect.Type) bool {
+	k := typ.Kind()
+	return (k == reflect.Struct && !isNullStruct(typ)) || k == reflect.Array
+}
+
+// binaryParser decodes unpadded URL-safe base64 (padding is tolerated) and
+// feeds the bytes to UnmarshalBinary. An empty string yields the zero value.
+func binaryParser(typ reflect.Type) ParserFunc {
+	return func(s string) (reflect.Value, error) {
+		if s == "" {
+			return reflect.Zero(typ), nil
+		}
+		raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		v := reflect.New(typ)
+		err = v.Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(raw)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return v.Elem(), nil
+	}
+}
+
+func binaryStringer(typ reflect.Type) StringerFunc {
+	return func(v reflect.Value) (string, error) {
+		if !typ.AssignableTo(binaryMarshaller) {
+			if !v.CanAddr() { // e.g. a struct passed by value
+				cp := reflect.New(typ).Elem()
+				cp.Set(v)
+				v = cp
+			}
+			v = v.Addr()
+		}
+		raw, err := v.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return "", err
+		}
+		return base64.RawURLEncoding.EncodeToString(raw), nil
+	}
+}
+
+// headerListParser splits a comma-separated header like Accept or Vary into
+// its elements, trimming whitespace and dropping parameters like ;q=0.9.
+func headerListParser(typ reflect.Type) ParserFunc {
+	return func(s string) (reflect.Value, error) {
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			item, _, _ = strings.Cut(item, ";")
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return reflect.ValueOf(items).Convert(typ), nil
+	}
+}
+
+func headerListStringer(v reflect.Value) (string, error) {
+	items := make([]string, v.Len())
+	for i := range items {
+		items[i] = v.Index(i).String()
+	}
+	return strings.Join(items, ", "), nil
+}
+
+// groupedParser accepts integers with thousands separators, like 1,000,000.
+// Every group but the first must have exactly three digits.
+func groupedParser(parse ParserFunc) ParserFunc {
+	return func(s string) (reflect.Value, error) {
+		if strings.Contains(s, ",") {
+			groups := strings.Split(strings.TrimPrefix(s, "-"), ",")
+			valid := len(groups[0]) >= 1 && len(groups[0]) <= 3
+			for _, g := range groups[1:] {
+				valid = valid && len(g) == 3
+			}
+			if !valid {
+				return reflect.Value{}, fmt.Errorf("invalid digit grouping in %q", s)
+			}
+		}
+		return parse(strings.ReplaceAll(s, ",", ""))
+	}
+}
+
+// quotedParser also accepts values in double quotes, like "42", matching
+// json:",string" fields that expect numbers and bools as JSON strings.
+func quotedParser(parse ParserFunc) ParserFunc {
+	return func(s string) (reflect.Value, error) {
+		if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+			u, err := strconv.Unquote(s)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			s = u
+		}
+		return parse(s)
+	}
+}
+
+// isJSONQuotable reports whether json:",string" applies to typ, excluding
+// strings whose quoting would be ambiguous in a form value.
+func isJSONQuotable(typ reflect.Type) bool {
+	if typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	k := typ.Kind()
+	return k == reflect.Bool || k == reflect.Float32 || k == reflect.Float64 || isIntegerKind(k)
+}
+
+// groupedStringer formats integers with thousands separators, like 1,000,000.
+func groupedStringer(stringify StringerFunc) StringerFunc {
+	return func(v reflect.Value) (string, error) {
+		s, err := stringify(v)
+		if err != nil {
+			return "", err
+		}
+		return groupDigits(s), nil
+	}
+}
+
+func groupDigits(s string) string {
+	var sign string
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	if len(s) <= 3 {
+		return sign + s
+	}
+	var buf strings.Builder
+	buf.WriteString(sign)
+	first := len(s) % 3
+	if first == 0 {
+		first = 3
+	}
+	buf.WriteString(s[:first])
+	for i := first; i < len(s); i += 3 {
+		buf.WriteByte(',')
+		buf.WriteString(s[i : i+3])
 	}
+	return buf.String()
 }
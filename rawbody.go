@@ -0,0 +1,79 @@
+package httpform
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+const defaultRawBodyMemoryLimit = 1 * MB
+
+// spooledBody is a request body kept for io.ReadSeekCloser rawbody fields,
+// in memory up to Configuration.RawBodyMemoryLimit and in a temp file past it.
+type spooledBody struct {
+	data []byte
+	file *os.File
+	size int64
+}
+
+func spoolBody(r io.Reader, memLimit int64) (*spooledBody, error) {
+	data, err := io.ReadAll(io.LimitReader(r, memLimit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) <= memLimit {
+		return &spooledBody{data: data, size: int64(len(data))}, nil
+	}
+
+	f, err := os.CreateTemp("", "httpform-rawbody-*")
+	if err != nil {
+		return nil, err
+	}
+	b := &spooledBody{file: f}
+	n, err := f.Write(data)
+	if err == nil {
+		var m int64
+		m, err = io.Copy(f, r)
+		b.size = int64(n) + m
+	}
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// reader returns a new reader of the entire body, independent of others.
+func (b *spooledBody) reader() io.Reader {
+	if b.file != nil {
+		return io.NewSectionReader(b.file, 0, b.size)
+	}
+	return bytes.NewReader(b.data)
+}
+
+// readSeekCloser returns the body for the field, which takes over the temp
+// file, if any: closing it removes the file.
+func (b *spooledBody) readSeekCloser() io.ReadSeekCloser {
+	if b.file != nil {
+		return &spooledBodyReader{io.NewSectionReader(b.file, 0, b.size), b}
+	}
+	return &spooledBodyReader{bytes.NewReader(b.data), b}
+}
+
+func (b *spooledBody) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	err := b.file.Close()
+	os.Remove(b.file.Name())
+	return err
+}
+
+type spooledBodyReader struct {
+	io.ReadSeeker
+	body *spooledBody
+}
+
+func (r *spooledBodyReader) Close() error {
+	return r.body.Close()
+}
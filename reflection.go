@@ -2,25 +2,60 @@ package httpform
 
 import (
 	"fmt"
+	"io"
+	"mime/multipart"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 )
 
 var (
-	requestType   = reflect.TypeOf((*http.Request)(nil))
-	urlType       = reflect.TypeOf((*url.URL)(nil))
-	urlValuesType = reflect.TypeOf((url.Values)(nil))
-	headersType   = reflect.TypeOf((http.Header)(nil))
+	cookieType         = reflect.TypeOf(http.Cookie{})
+	cookiePtrType      = reflect.TypeOf((*http.Cookie)(nil))
+	cookieSliceType    = reflect.TypeOf([]*http.Cookie(nil))
+	requestType        = reflect.TypeOf((*http.Request)(nil))
+	urlType            = reflect.TypeOf((*url.URL)(nil))
+	urlValuesType      = reflect.TypeOf((url.Values)(nil))
+	urlValuesPtrType   = reflect.TypeOf((*url.Values)(nil))
+	anyMapPtrType      = reflect.TypeOf((*map[string]any)(nil))
+	headersType        = reflect.TypeOf((http.Header)(nil))
+	readerType         = reflect.TypeOf((*io.Reader)(nil)).Elem()
+	readCloserType     = reflect.TypeOf((*io.ReadCloser)(nil)).Elem()
+	readSeekCloserType = reflect.TypeOf((*io.ReadSeekCloser)(nil)).Elem()
+	filePtrType        = reflect.TypeOf((*multipart.FileHeader)(nil))
+	fileSliceType      = reflect.TypeOf([]*multipart.FileHeader(nil))
+	netipAddrType      = reflect.TypeOf(netip.Addr{})
+	stringType         = reflect.TypeOf("")
 )
 
 type structMeta struct {
-	NamedFields   map[string]*fieldMeta
-	UnnamedFields []*fieldMeta
-	HasRawBody    bool
-	HasFullBody   bool
-	HasBodyForm   bool
+	NamedFields       map[string]*fieldMeta
+	OrderedFields     []*fieldMeta // NamedFields in declaration order
+	UnnamedFields     []*fieldMeta
+	JSONSkippedFields []*fieldMeta          // readonly and queryonly fields that the JSON decoder must not touch
+	NullableFields    []*fieldMeta          // nullable form fields, reset to null when absent
+	ImmutableFields   []*fieldMeta          // fields checked against DecodeOptions.Current
+	BoolFields        []*fieldMeta          // bool form fields, for Configuration.LenientJSONBools
+	RequiredFields    []*fieldMeta          // form fields with the required modifier
+	ConditionalFields []*fieldMeta          // form fields with requiredwith or requiredwithout
+	AlternateParams   map[string]bool       // query params read by fields as alternates, not unknown
+	FoldedFields      map[string]*fieldMeta // lowercased names of form fields, for CaseInsensitiveFields
+	DefaultFields     []*fieldMeta          // form fields with the default modifier
+	DerivedFields     []*fieldMeta          // fields with defaultfrom, in declaration order
+	AliasFields       []*fieldMeta          // fields with aliasof, in declaration order
+	SortedFields      []*fieldMeta          // slice fields with the sort modifier
+	HasRawBody        bool
+	SpoolsRawBody     bool // has an io.ReadSeekCloser rawbody field
+	HasFullBody       bool
+	HasBodyStream     bool // body is handed over unread, so body params aren't parsed
+	HasBodyForm       bool
+	HasFlags          bool
+	HasQueryOnly      bool
+	UsesForm          bool // some fields come from the query string or the body
 }
 
 type specialMeta struct {
@@ -29,14 +64,66 @@ type specialMeta struct {
 }
 
 type fieldMeta struct {
-	fieldIdx   int
-	name       string
-	Parse      ParserFunc
-	Stringify  StringerFunc
-	Source     source
-	Optional   bool
-	NotInBody  bool
-	IsJSONOnly bool
+	fieldIdx        int
+	name            string
+	Parse           ParserFunc
+	ParseItem       ParserFunc // for slices populated from multiple values
+	ParseEntry      ParserFunc // for maps populated from name[key] params
+	StringifyEntry  StringerFunc
+	Stringify       StringerFunc
+	Source          source
+	Direction       direction
+	Group           bool
+	IsFlag          bool
+	Nullable        bool
+	MaxItems        int
+	CaseInsensitive bool
+	OmitEmpty       bool
+	Optional        bool
+	NotInBody       bool
+	IsJSONOnly      bool // from the JSON body only, never parsed from form values
+	QueryOnly       bool // from the query string only, never from the body
+	Immutable       bool
+	Hidden          bool // for form generation via HiddenFields, doesn't affect decoding
+	HeaderList      bool // a comma-separated list header, possibly sent in several lines
+	Required        bool
+	RequiredWith    []string    // names of fields that make this one required when set
+	RequiredWithout []string    // names of fields that make this one required when unset
+	FileKey         string      // multipart file to read into a filebytes field
+	ContextName     string      // name of the context key registered via RegisterContextKey
+	ContextKey      any         // key of the r.Context() value for a context field
+	Alternates      []altSource // tried in order when the primary source has no value
+	Default         string      // raw value to use when the request has none, if HasDefault
+	HasDefault      bool
+	DefaultFrom     string // name of the field to copy when this one ends up empty
+	AliasOf         string // name of the field to copy this one into when that ends up empty
+	Sort            bool
+	SortKey         StringerFunc // for sorting items of types that aren't naturally ordered
+}
+
+// altSource is another place to look for a field's value, e.g. a query param
+// for an API key that normally comes in a header.
+type altSource struct {
+	Source source
+	Name   string
+}
+
+// direction limits a field to requests (writeonly) or responses (readonly),
+// so that one struct can serve as both request and response DTO.
+type direction int
+
+const (
+	bothDirs     = direction(iota)
+	requestOnly  // writeonly: decoded, never encoded
+	responseOnly // readonly: encoded, never decoded
+)
+
+func (fm *fieldMeta) IsDecodable() bool {
+	return fm.Direction != responseOnly
+}
+
+func (fm *fieldMeta) IsEncodable() bool {
+	return fm.Direction != requestOnly
 }
 
 func getVal(structVal reflect.Value, fm *fieldMeta) reflect.Value {
@@ -44,16 +131,41 @@ func getVal(structVal reflect.Value, fm *fieldMeta) reflect.Value {
 }
 
 func getString(structVal reflect.Value, fm *fieldMeta) string {
-	v := getVal(structVal, fm)
+	s, err := stringField(structVal, fm)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
 
+func stringField(structVal reflect.Value, fm *fieldMeta) (string, error) {
+	v := getVal(structVal, fm)
 	s, err := fm.Stringify(v)
 	if err != nil {
-		panic(fmt.Errorf("failed to encode value of %s: %v", fm.name, v.Interface()))
+		return "", fmt.Errorf("failed to encode value of %s: %w", fm.name, err)
 	}
-	return s
+	return s, nil
+}
+
+// isEmptyValue matches the definition of empty in encoding/json's omitempty.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	}
+	return false
 }
 
-func setVal(structVal reflect.Value, sm *structMeta, src source, name string, rawValue string) error {
+// setVals sets a named field from all values of a param. When the param is
+// repeated, slice fields get one item per value, and other fields take the
+// last value; a single value is split into items by the field's separator.
+func setVals(structVal reflect.Value, sm *structMeta, src source, name string, rawValues []string) error {
 	fm := sm.NamedFields[name]
 	if fm == nil || fm.Source != src {
 		if src != formSrc {
@@ -61,7 +173,52 @@ func setVal(structVal reflect.Value, sm *structMeta, src source, name string, ra
 		}
 		return nil
 	}
-	return setField(structVal, fm, rawValue)
+	if !fm.IsDecodable() || fm.Parse == nil || fm.IsJSONOnly {
+		return nil
+	}
+	value, err := parseVals(structVal, fm, rawValues)
+	if err != nil {
+		return err
+	}
+	setFieldVal(structVal, fm, value)
+	return nil
+}
+
+func parseVals(structVal reflect.Value, fm *fieldMeta, rawValues []string) (reflect.Value, error) {
+	if len(rawValues) > 1 && fm.ParseItem != nil {
+		return parseItems(structVal.Field(fm.fieldIdx).Type(), fm, rawValues)
+	}
+	value, err := fm.Parse(rawValues[len(rawValues)-1])
+	if err != nil {
+		return reflect.Value{}, fmt.Errorf("invalid %s: %w", fm.name, err)
+	}
+	return value, nil
+}
+
+// checkConflict reports an error if a field the body has set differs from
+// rawValues.
+func checkConflict(structVal reflect.Value, sm *structMeta, name string, rawValues []string) error {
+	fm := sm.NamedFields[name]
+	if fm == nil || fm.Source != formSrc || !fm.IsDecodable() || fm.Parse == nil {
+		return nil
+	}
+	cur := structVal.Field(fm.fieldIdx)
+	value, err := parseVals(structVal, fm, rawValues)
+	if err != nil || !value.CanConvert(cur.Type()) {
+		return nil // setVals will report the problem
+	}
+	if !reflect.DeepEqual(cur.Interface(), value.Convert(cur.Type()).Interface()) {
+		return fmt.Errorf("conflicting values of %s in query string and body", fm.name)
+	}
+	return nil
+}
+
+// setDefault sets a field to its default value, which examineField has
+// already checked to parse.
+func setDefault(structVal reflect.Value, fm *fieldMeta) {
+	if err := setField(structVal, fm, fm.Default); err != nil {
+		panic(err)
+	}
 }
 
 func setField(structVal reflect.Value, fm *fieldMeta, rawValue string) error {
@@ -73,6 +230,161 @@ func setField(structVal reflect.Value, fm *fieldMeta, rawValue string) error {
 	return nil
 }
 
+// maxIndex bounds explicit indices like items.9999, so that a malicious
+// client cannot make us allocate a huge slice.
+const maxIndex = 9999
+
+// lookupIndexed finds the slice field for an explicitly indexed key like
+// items.0. Fields whose names literally match the key take precedence, so
+// legacy dotted names like user.name (or even items.9) keep working as
+// plain fields; dots only route into a slice when nothing else matches.
+func (sm *structMeta) lookupIndexed(key string) (*fieldMeta, int, bool) {
+	if sm.NamedFields[key] != nil {
+		return nil, 0, false
+	}
+	dot := strings.LastIndexByte(key, '.')
+	if dot < 0 {
+		return nil, 0, false
+	}
+	fm := sm.NamedFields[key[:dot]]
+	if fm == nil || fm.Source != formSrc || fm.ParseItem == nil || !fm.IsDecodable() || fm.IsJSONOnly {
+		return nil, 0, false
+	}
+	idx, err := strconv.Atoi(key[dot+1:])
+	if err != nil || idx < 0 {
+		return nil, 0, false
+	}
+	return fm, idx, true
+}
+
+// lookupMapEntry finds the map field for a Rails-style key like meta[color].
+// Like with lookupIndexed, fields literally named meta[color] win.
+func (sm *structMeta) lookupMapEntry(key string) (*fieldMeta, string, bool) {
+	if sm.NamedFields[key] != nil || !strings.HasSuffix(key, "]") {
+		return nil, "", false
+	}
+	open := strings.IndexByte(key, '[')
+	if open <= 0 {
+		return nil, "", false
+	}
+	fm := sm.NamedFields[key[:open]]
+	if fm == nil || fm.Source != formSrc || fm.ParseEntry == nil || !fm.IsDecodable() || fm.IsJSONOnly {
+		return nil, "", false
+	}
+	return fm, key[open+1 : len(key)-1], true
+}
+
+func setMapEntries(structVal reflect.Value, fm *fieldMeta, entries map[string]string) error {
+	fieldTyp := structVal.Field(fm.fieldIdx).Type()
+	mapVal := reflect.MakeMapWithSize(fieldTyp, len(entries))
+	for k, rawValue := range entries {
+		value, err := fm.ParseEntry(rawValue)
+		if err != nil {
+			return fmt.Errorf("invalid %s[%s]: %w", fm.name, k, err)
+		}
+		mapVal.SetMapIndex(reflect.ValueOf(k).Convert(fieldTyp.Key()), value.Convert(fieldTyp.Elem()))
+	}
+	setFieldVal(structVal, fm, mapVal)
+	return nil
+}
+
+// encodeMapEntries adds name[key]=value params for a map field, sorted by key.
+func encodeMapEntries(structVal reflect.Value, fm *fieldMeta, values url.Values) error {
+	mapVal := structVal.Field(fm.fieldIdx)
+	keys := mapVal.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	for _, k := range keys {
+		s, err := fm.StringifyEntry(mapVal.MapIndex(k))
+		if err != nil {
+			return fmt.Errorf("failed to encode value of %s[%s]: %w", fm.name, k.String(), err)
+		}
+		values.Set(fm.name+"["+k.String()+"]", s)
+	}
+	return nil
+}
+
+func setIndexedItems(structVal reflect.Value, fm *fieldMeta, items map[int]string, zeroFill bool) error {
+	n := 0
+	for idx := range items {
+		if idx > maxIndex || (fm.MaxItems > 0 && idx >= fm.MaxItems) {
+			return fmt.Errorf("invalid %s: index %d is too large", fm.name, idx)
+		}
+		if idx >= n {
+			n = idx + 1
+		}
+	}
+	fieldTyp := structVal.Field(fm.fieldIdx).Type()
+	sliceVal := reflect.MakeSlice(fieldTyp, n, n)
+	for idx := 0; idx < n; idx++ {
+		rawValue, found := items[idx]
+		if !found {
+			if zeroFill {
+				continue
+			}
+			return fmt.Errorf("invalid %s: missing index %d", fm.name, idx)
+		}
+		value, err := fm.ParseItem(rawValue)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", fm.name, err)
+		}
+		sliceVal.Index(idx).Set(value)
+	}
+	setFieldVal(structVal, fm, sliceVal)
+	return nil
+}
+
+// setCookieField stores the first (most specific) cookie, or all of them.
+// Note that requests only carry cookie names and values; attributes like
+// Path and Expires are only ever sent by servers.
+func setCookieField(fv reflect.Value, cc []*http.Cookie) {
+	switch fv.Type() {
+	case cookiePtrType:
+		fv.Set(reflect.ValueOf(cc[0]))
+	case cookieType:
+		fv.Set(reflect.ValueOf(*cc[0]))
+	case cookieSliceType:
+		fv.Set(reflect.ValueOf(cc))
+	}
+}
+
+func setFileField(fv reflect.Value, files []*multipart.FileHeader) {
+	if fv.Type() == fileSliceType {
+		fv.Set(reflect.ValueOf(files))
+	} else {
+		fv.Set(reflect.ValueOf(files[0]))
+	}
+}
+
+func setNull(structVal reflect.Value, fm *fieldMeta) {
+	fv := structVal.Field(fm.fieldIdx)
+	fv.Set(reflect.Zero(fv.Type()))
+}
+
+func setFieldItems(structVal reflect.Value, fm *fieldMeta, rawValues []string) error {
+	sliceVal, err := parseItems(structVal.Field(fm.fieldIdx).Type(), fm, rawValues)
+	if err != nil {
+		return err
+	}
+	setFieldVal(structVal, fm, sliceVal)
+	return nil
+}
+
+// parseItems parses each raw value as a single item of a slice field.
+func parseItems(fieldTyp reflect.Type, fm *fieldMeta, rawValues []string) (reflect.Value, error) {
+	if fm.MaxItems > 0 && len(rawValues) > fm.MaxItems {
+		return reflect.Value{}, fmt.Errorf("invalid %s: %w", fm.name, tooManyItems(fm.MaxItems))
+	}
+	sliceVal := reflect.MakeSlice(fieldTyp, 0, len(rawValues))
+	for _, rawValue := range rawValues {
+		value, err := fm.ParseItem(rawValue)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("invalid %s: %w", fm.name, err)
+		}
+		sliceVal = reflect.Append(sliceVal, value)
+	}
+	return sliceVal, nil
+}
+
 func setFieldVal(structVal reflect.Value, fm *fieldMeta, val reflect.Value) {
 	fieldVal := structVal.Field(fm.fieldIdx)
 	fieldTyp := fieldVal.Type()
@@ -85,6 +397,148 @@ func setFieldVal(structVal reflect.Value, fm *fieldMeta, val reflect.Value) {
 	fieldVal.Set(val.Convert(fieldTyp))
 }
 
+// checkImmutableFields copies immutable fields left unset by the request
+// from current, and rejects those set to a different value.
+func checkImmutableFields(destVal, current reflect.Value, fields []*fieldMeta) error {
+	for _, fm := range fields {
+		f, cur := destVal.Field(fm.fieldIdx), current.Field(fm.fieldIdx)
+		if f.IsZero() {
+			f.Set(cur)
+		} else if !reflect.DeepEqual(f.Interface(), cur.Interface()) {
+			return &Error{http.StatusBadRequest, fmt.Sprintf("%s cannot be changed", fm.name), nil}
+		}
+	}
+	return nil
+}
+
+// checkConditionalFields enforces requiredwith and requiredwithout, treating
+// zero values as unset.
+func checkConditionalFields(structVal reflect.Value, sm *structMeta, ec *errorCollector) error {
+	for _, fm := range sm.ConditionalFields {
+		if !structVal.Field(fm.fieldIdx).IsZero() {
+			continue
+		}
+		for _, name := range fm.RequiredWith {
+			if !structVal.Field(sm.NamedFields[name].fieldIdx).IsZero() {
+				if err := ec.fail(fm.name, &Error{http.StatusBadRequest, fmt.Sprintf("missing parameter %s, required when %s is set", fm.name, name), nil}); err != nil {
+					return err
+				}
+			}
+		}
+		for _, name := range fm.RequiredWithout {
+			if structVal.Field(sm.NamedFields[name].fieldIdx).IsZero() {
+				if err := ec.fail(fm.name, &Error{http.StatusBadRequest, fmt.Sprintf("missing parameter %s, required when %s is not set", fm.name, name), nil}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// isJSONField reports whether a top-level key of a JSON body sets a field.
+func (sm *structMeta) isJSONField(key string) bool {
+	fm := sm.NamedFields[key]
+	return fm != nil && fm.Source == formSrc && fm.IsDecodable() && !fm.QueryOnly
+}
+
+// applyDefaultsFrom fills empty fields with the defaultfrom modifier from
+// the fields they reference, in declaration order, so chains work when
+// declared in dependency order. Empty fields named by aliasof are filled
+// from their aliases first.
+func applyDefaultsFrom(structVal reflect.Value, sm *structMeta) {
+	// aliases go first, so that fields defaulting from a canonical field
+	// see the value sent under its deprecated name
+	for _, fm := range sm.AliasFields {
+		fv := structVal.Field(fm.fieldIdx)
+		to := structVal.Field(sm.NamedFields[fm.AliasOf].fieldIdx)
+		if to.IsZero() && !fv.IsZero() {
+			to.Set(fv)
+		}
+	}
+	for _, fm := range sm.DerivedFields {
+		fv := structVal.Field(fm.fieldIdx)
+		if fv.IsZero() {
+			fv.Set(structVal.Field(sm.NamedFields[fm.DefaultFrom].fieldIdx))
+		}
+	}
+}
+
+// sortFields sorts the slices of fields with the sort modifier, comparing
+// the stringified items when they aren't numbers or strings.
+func sortFields(structVal reflect.Value, sm *structMeta) error {
+	for _, fm := range sm.SortedFields {
+		fv := structVal.Field(fm.fieldIdx)
+		if fv.Len() < 2 {
+			continue
+		}
+		if fm.SortKey == nil {
+			sort.SliceStable(fv.Interface(), func(i, j int) bool {
+				return lessOrdered(fv.Index(i), fv.Index(j))
+			})
+			continue
+		}
+		keys := make([]string, fv.Len())
+		for i := range keys {
+			s, err := fm.SortKey(fv.Index(i))
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", fm.name, err)
+			}
+			keys[i] = s
+		}
+		// sort a permutation, so that each key is only computed once
+		idx := make([]int, len(keys))
+		for i := range idx {
+			idx[i] = i
+		}
+		sort.SliceStable(idx, func(a, b int) bool { return keys[idx[a]] < keys[idx[b]] })
+		sorted := reflect.MakeSlice(fv.Type(), fv.Len(), fv.Len())
+		for i, from := range idx {
+			sorted.Index(i).Set(fv.Index(from))
+		}
+		fv.Set(sorted)
+	}
+	return nil
+}
+
+func isOrderedKind(k reflect.Kind) bool {
+	return isNumberKind(k) || k == reflect.String
+}
+
+func lessOrdered(a, b reflect.Value) bool {
+	switch {
+	case a.CanInt():
+		return a.Int() < b.Int()
+	case a.CanUint():
+		return a.Uint() < b.Uint()
+	case a.CanFloat():
+		return a.Float() < b.Float()
+	default:
+		return a.String() < b.String()
+	}
+}
+
+// saveFields and restoreFields protect readonly fields from decoders that
+// don't know about httpform tags, like encoding/json.
+func saveFields(structVal reflect.Value, fields []*fieldMeta) []reflect.Value {
+	if len(fields) == 0 {
+		return nil
+	}
+	saved := make([]reflect.Value, len(fields))
+	for i, fm := range fields {
+		fv := structVal.Field(fm.fieldIdx)
+		saved[i] = reflect.New(fv.Type()).Elem()
+		saved[i].Set(fv)
+	}
+	return saved
+}
+
+func restoreFields(structVal reflect.Value, fields []*fieldMeta, saved []reflect.Value) {
+	for i, fm := range fields {
+		structVal.Field(fm.fieldIdx).Set(saved[i])
+	}
+}
+
 func (conf *Configuration) lookupStruct(structTyp reflect.Type) *structMeta {
 	v, _ := conf.structCache.Load(structTyp)
 	if v != nil {
@@ -96,6 +550,50 @@ func (conf *Configuration) lookupStruct(structTyp reflect.Type) *structMeta {
 	return sm
 }
 
+// RequiredFields returns the names of the fields of struct type t (or a
+// pointer to one) that a request must provide: path params and headers that
+// are neither optional, nullable nor defaulted, non-optional files, and form fields with the
+// required modifier.
+func (conf *Configuration) RequiredFields(t reflect.Type) []string {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	var names []string
+	for _, fm := range conf.lookupStruct(t).OrderedFields {
+		var required bool
+		switch fm.Source {
+		case pathSrc:
+			required = !fm.Optional && !fm.HasDefault
+		case headerSrc:
+			required = !fm.Optional && !fm.Nullable && !fm.HasDefault && fm.IsDecodable()
+		case formSrc:
+			required = fm.Required
+		case fileSrc, fileBytesSrc:
+			required = !fm.Optional
+		}
+		if required {
+			names = append(names, fm.name)
+		}
+	}
+	return names
+}
+
+// HiddenFields returns the names of the fields of struct type t (or a
+// pointer to one) tagged with the hidden modifier, for form generators to
+// render as <input type=hidden> or leave out. Decoding ignores the modifier.
+func (conf *Configuration) HiddenFields(t reflect.Type) []string {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	var names []string
+	for _, fm := range conf.lookupStruct(t).OrderedFields {
+		if fm.Hidden {
+			names = append(names, fm.name)
+		}
+	}
+	return names
+}
+
 func (conf *Configuration) examineStruct(structTyp reflect.Type) *structMeta {
 	n := structTyp.NumField()
 	sm := &structMeta{
@@ -106,19 +604,117 @@ func (conf *Configuration) examineStruct(structTyp reflect.Type) *structMeta {
 		fm := conf.examineField(i, &field, structTyp)
 		if fm != nil {
 			if fm.Source.IsNamed() {
+				if prev := sm.NamedFields[fm.name]; prev != nil {
+					sm.OrderedFields[indexOf(sm.OrderedFields, prev)] = fm
+				} else {
+					sm.OrderedFields = append(sm.OrderedFields, fm)
+				}
 				sm.NamedFields[fm.name] = fm
 			} else {
 				sm.UnnamedFields = append(sm.UnnamedFields, fm)
 			}
+			if (fm.Direction == responseOnly || fm.QueryOnly) && fm.Source == formSrc {
+				sm.JSONSkippedFields = append(sm.JSONSkippedFields, fm)
+			}
 			if fm.Source == rawBodySrc {
 				sm.HasRawBody = true
+				if field.Type == readSeekCloserType {
+					sm.SpoolsRawBody = true
+				}
 			} else if fm.Source == fullBodySrc {
 				sm.HasFullBody = true
-			} else if fm.Source == formSrc && !fm.NotInBody {
+			} else if fm.Source == bodySrc {
+				sm.HasBodyStream = true
+			} else if fm.Source == formSrc && !fm.NotInBody && !fm.QueryOnly {
 				sm.HasBodyForm = true
 			}
+			if fm.IsFlag {
+				sm.HasFlags = true
+			}
+			if fm.QueryOnly {
+				sm.HasQueryOnly = true
+			}
+			if fm.Source.Mask()&FormSource != 0 {
+				sm.UsesForm = true
+			}
+			if fm.Nullable && fm.Source == formSrc {
+				sm.NullableFields = append(sm.NullableFields, fm)
+			}
+			if fm.Immutable {
+				sm.ImmutableFields = append(sm.ImmutableFields, fm)
+			}
+			if fm.Required {
+				sm.RequiredFields = append(sm.RequiredFields, fm)
+			}
+			if fm.RequiredWith != nil || fm.RequiredWithout != nil {
+				sm.ConditionalFields = append(sm.ConditionalFields, fm)
+			}
+			if fm.HasDefault && fm.Source == formSrc {
+				sm.DefaultFields = append(sm.DefaultFields, fm)
+			}
+			if fm.DefaultFrom != "" {
+				sm.DerivedFields = append(sm.DerivedFields, fm)
+			}
+			if fm.AliasOf != "" {
+				sm.AliasFields = append(sm.AliasFields, fm)
+			}
+			if fm.Sort {
+				sm.SortedFields = append(sm.SortedFields, fm)
+			}
+			for _, alt := range fm.Alternates {
+				if sm.AlternateParams == nil {
+					sm.AlternateParams = make(map[string]bool)
+				}
+				sm.AlternateParams[alt.Name] = true
+			}
+			if fm.Source == formSrc && fm.IsDecodable() && isBoolType(field.Type) {
+				sm.BoolFields = append(sm.BoolFields, fm)
+			}
 		}
 	}
+	if conf.CaseInsensitiveFields {
+		sm.FoldedFields = make(map[string]*fieldMeta)
+		for _, fm := range sm.OrderedFields {
+			if fm.Source != formSrc {
+				continue
+			}
+			folded := strings.ToLower(fm.name)
+			if prev := sm.FoldedFields[folded]; prev != nil {
+				panic(fmt.Errorf("struct %v has fields %q and %q that differ only by case, which CaseInsensitiveFields cannot tell apart", structTyp, prev.name, fm.name))
+			}
+			sm.FoldedFields[folded] = fm
+		}
+	}
+	for _, fm := range sm.ConditionalFields {
+		for _, names := range [][]string{fm.RequiredWith, fm.RequiredWithout} {
+			for _, name := range names {
+				if sm.NamedFields[name] == nil {
+					panic(fmt.Errorf("field %v.%s depends on unknown field %q", structTyp, structTyp.Field(fm.fieldIdx).Name, name))
+				}
+			}
+		}
+	}
+	for _, fm := range sm.DerivedFields {
+		from := sm.NamedFields[fm.DefaultFrom]
+		if from == nil || from == fm || from.Source == cookieStructSrc {
+			panic(fmt.Errorf("field %v.%s takes its default from unknown field %q", structTyp, structTyp.Field(fm.fieldIdx).Name, fm.DefaultFrom))
+		}
+		if fromTyp, typ := structTyp.Field(from.fieldIdx).Type, structTyp.Field(fm.fieldIdx).Type; !fromTyp.AssignableTo(typ) {
+			panic(fmt.Errorf("field %v.%s is %v and cannot take its default from field %q of type %v", structTyp, structTyp.Field(fm.fieldIdx).Name, typ, fm.DefaultFrom, fromTyp))
+		}
+	}
+	for _, fm := range sm.AliasFields {
+		to := sm.NamedFields[fm.AliasOf]
+		if to == nil || to == fm || to.Source == cookieStructSrc {
+			panic(fmt.Errorf("field %v.%s is an alias of unknown field %q", structTyp, structTyp.Field(fm.fieldIdx).Name, fm.AliasOf))
+		}
+		if typ, toTyp := structTyp.Field(fm.fieldIdx).Type, structTyp.Field(to.fieldIdx).Type; !typ.AssignableTo(toTyp) {
+			panic(fmt.Errorf("field %v.%s is %v and cannot be an alias of field %q of type %v", structTyp, structTyp.Field(fm.fieldIdx).Name, typ, fm.AliasOf, toTyp))
+		}
+	}
+	if sm.HasBodyStream && (sm.HasRawBody || sm.HasFullBody) {
+		panic(fmt.Errorf("struct %v cannot have both body and rawbody/fullbody fields", structTyp))
+	}
 	return sm
 }
 
@@ -137,13 +733,17 @@ func (conf *Configuration) examineField(fieldIdx int, field *reflect.StructField
 		src = queryValuesSrc
 	} else if fieldTyp == headersType {
 		src = headersSrc
+	} else if fieldTyp == filePtrType || fieldTyp == fileSliceType {
+		src = fileSrc
 	}
 
 	jsonTag, jsonPresent := field.Tag.Lookup("json")
 	var (
-		jsonName    string = field.Name
-		jsonNamed   bool
-		jsonSkipped bool
+		jsonName      string = field.Name
+		jsonNamed     bool
+		jsonSkipped   bool
+		jsonOmitEmpty bool
+		jsonQuoted    bool
 	)
 	if jsonPresent {
 		comps := strings.Split(jsonTag, ",")
@@ -152,15 +752,43 @@ func (conf *Configuration) examineField(fieldIdx int, field *reflect.StructField
 			jsonNamed = true
 			jsonSkipped = (n == "-")
 		}
+		for _, opt := range comps[1:] {
+			if opt == "omitempty" {
+				jsonOmitEmpty = true
+			} else if opt == "string" {
+				jsonQuoted = true
+			}
+		}
 	}
 
 	formTag, formPresent := field.Tag.Lookup("form")
 	var (
-		formName    string
-		isOptional  bool
-		isNotInBody bool
-		isJSONOnly  bool
-		ropt        = fieldStringRepresenationOpts{sep: ' '}
+		formName        string
+		isOptional      bool
+		isNotInBody     bool
+		isJSONOnly      bool
+		isQueryOnly     bool
+		isSorted        bool
+		isGroup         bool
+		isFlag          bool
+		isNullable      bool
+		isImmutable     bool
+		isHidden        bool
+		isRequired      bool
+		requiredWith    []string
+		requiredWithout []string
+		fileKey         string
+		contextName     string
+		alternates      []altSource
+		defaultValue    string
+		hasDefault      bool
+		defaultFrom     string
+		aliasOf         string
+		isNested        bool
+		isHeaderList    bool
+		isOmitEmpty     = jsonOmitEmpty
+		dir             = bothDirs
+		ropt            = fieldStringRepresenationOpts{sep: ' '}
 	)
 	if formPresent {
 		comps := strings.Split(formTag, ",")
@@ -183,6 +811,14 @@ func (conf *Configuration) examineField(fieldIdx int, field *reflect.StructField
 					panic(fmt.Errorf(`field %v.%s has conflicting modifier %q in form:%q tag`, structTyp, field.Name, mod, formTag))
 				}
 				src = cookieSrc
+			case "cookiestruct":
+				if src != noSrc {
+					panic(fmt.Errorf(`field %v.%s has conflicting modifier %q in form:%q tag`, structTyp, field.Name, mod, formTag))
+				}
+				if fieldTyp != cookiePtrType && fieldTyp != cookieType && fieldTyp != cookieSliceType {
+					panic(fmt.Errorf(`field %v.%s is sourced from cookiestruct and must be *http.Cookie, http.Cookie or []*http.Cookie, got %v`, structTyp, field.Name, fieldTyp))
+				}
+				src = cookieStructSrc
 			case "header":
 				if src != noSrc {
 					panic(fmt.Errorf(`field %v.%s has conflicting modifier %q in form:%q tag`, structTyp, field.Name, mod, formTag))
@@ -208,12 +844,113 @@ func (conf *Configuration) examineField(fieldIdx int, field *reflect.StructField
 					panic(fmt.Errorf(`field %v.%s has conflicting modifier %q in form:%q tag`, structTyp, field.Name, mod, formTag))
 				}
 				src = fullBodySrc
+			case "rawquery":
+				if src != noSrc {
+					panic(fmt.Errorf(`field %v.%s has conflicting modifier %q in form:%q tag`, structTyp, field.Name, mod, formTag))
+				}
+				if fieldTyp.Kind() != reflect.String {
+					panic(fmt.Errorf(`field %v.%s is sourced from rawquery and must be a string, got %v`, structTyp, field.Name, fieldTyp))
+				}
+				src = rawQuerySrc
+			case "proto":
+				if src != noSrc {
+					panic(fmt.Errorf(`field %v.%s has conflicting modifier %q in form:%q tag`, structTyp, field.Name, mod, formTag))
+				}
+				if fieldTyp.Kind() != reflect.String {
+					panic(fmt.Errorf(`field %v.%s is sourced from proto and must be a string, got %v`, structTyp, field.Name, fieldTyp))
+				}
+				src = protoSrc
+			case "dispositionfilename":
+				if src != noSrc {
+					panic(fmt.Errorf(`field %v.%s has conflicting modifier %q in form:%q tag`, structTyp, field.Name, mod, formTag))
+				}
+				if fieldTyp.Kind() != reflect.String {
+					panic(fmt.Errorf(`field %v.%s is sourced from dispositionfilename and must be a string, got %v`, structTyp, field.Name, fieldTyp))
+				}
+				src = dispositionFilenameSrc
+			case "remoteip":
+				if src != noSrc {
+					panic(fmt.Errorf(`field %v.%s has conflicting modifier %q in form:%q tag`, structTyp, field.Name, mod, formTag))
+				}
+				if fieldTyp.Kind() != reflect.String && fieldTyp != netipAddrType {
+					panic(fmt.Errorf(`field %v.%s is sourced from remoteip and must be a string or netip.Addr, got %v`, structTyp, field.Name, fieldTyp))
+				}
+				src = remoteIPSrc
+			case "body":
+				if src != noSrc {
+					panic(fmt.Errorf(`field %v.%s has conflicting modifier %q in form:%q tag`, structTyp, field.Name, mod, formTag))
+				}
+				if fieldTyp != readerType && fieldTyp != readCloserType {
+					panic(fmt.Errorf(`field %v.%s is sourced from body and must be io.Reader or io.ReadCloser, got %v`, structTyp, field.Name, fieldTyp))
+				}
+				src = bodySrc
+			case "range":
+				if src != noSrc {
+					panic(fmt.Errorf(`field %v.%s has conflicting modifier %q in form:%q tag`, structTyp, field.Name, mod, formTag))
+				}
+				if fieldTyp != byteRangeType && fieldTyp != byteRangePtrType && fieldTyp != byteRangeSliceType {
+					panic(fmt.Errorf(`field %v.%s is sourced from range and must be ByteRange, *ByteRange or []ByteRange, got %v`, structTyp, field.Name, fieldTyp))
+				}
+				src = rangeSrc
+			case "basicauth":
+				if src != noSrc {
+					panic(fmt.Errorf(`field %v.%s has conflicting modifier %q in form:%q tag`, structTyp, field.Name, mod, formTag))
+				}
+				if fieldTyp != basicAuthType && fieldTyp != basicAuthPtrType {
+					panic(fmt.Errorf(`field %v.%s is sourced from basicauth and must be BasicAuth or *BasicAuth, got %v`, structTyp, field.Name, fieldTyp))
+				}
+				src = basicAuthSrc
 			case "notinbody":
 				isNotInBody = true
 			case "jsononly":
 				isJSONOnly = true
+			case "queryonly":
+				isQueryOnly = true
+			case "sort":
+				isSorted = true
+			case "trim":
+				ropt.trim = true
 			case "optional":
 				isOptional = true
+			case "readonly":
+				if dir != bothDirs {
+					panic(fmt.Errorf(`field %v.%s has conflicting modifier %q in form:%q tag`, structTyp, field.Name, mod, formTag))
+				}
+				dir = responseOnly
+			case "writeonly":
+				if dir != bothDirs {
+					panic(fmt.Errorf(`field %v.%s has conflicting modifier %q in form:%q tag`, structTyp, field.Name, mod, formTag))
+				}
+				dir = requestOnly
+			case "group":
+				isGroup = true
+			case "flag":
+				isFlag = true
+			case "nullable":
+				isNullable = true
+			case "immutable":
+				isImmutable = true
+			case "hidden":
+				isHidden = true
+			case "headerlist":
+				isHeaderList = true
+			case "required":
+				isRequired = true
+			case "nestedform":
+				isNested = true
+			case "omitempty":
+				isOmitEmpty = true
+			case "caseinsensitive":
+				ropt.caseInsensitive = true
+			case "enumnumbers":
+				ropt.enumNumbers = true
+			case "decimalcomma":
+				ropt.decimalComma = true
+			case "units":
+				if len(conf.units) == 0 {
+					panic(fmt.Errorf(`field %v.%s has modifier "units" in form:%q tag, which requires units registered via RegisterUnit`, structTyp, field.Name, formTag))
+				}
+				ropt.units = conf.units
 			case "sep=comma":
 				ropt.sep = ','
 			case "sep=semicolon":
@@ -221,13 +958,59 @@ func (conf *Configuration) examineField(fieldIdx int, field *reflect.StructField
 			case "sep=colon":
 				ropt.sep = ':'
 			default:
-				panic(fmt.Errorf(`field %v.%s has unknown modifier %q in form:%q tag`, structTyp, field.Name, mod, formTag))
+				key, arg, hasArg := strings.Cut(mod, "=")
+				if !hasArg {
+					panic(fmt.Errorf(`field %v.%s has unknown modifier %q in form:%q tag`, structTyp, field.Name, mod, formTag))
+				}
+				switch key {
+				case "maxitems":
+					n, err := strconv.Atoi(arg)
+					if err != nil || n <= 0 {
+						panic(fmt.Errorf(`field %v.%s has invalid modifier %q in form:%q tag`, structTyp, field.Name, mod, formTag))
+					}
+					ropt.maxItems = n
+				case "requiredwith":
+					requiredWith = append(requiredWith, arg)
+				case "requiredwithout":
+					requiredWithout = append(requiredWithout, arg)
+				case "default":
+					defaultValue, hasDefault = arg, true
+				case "defaultfrom":
+					defaultFrom = arg
+				case "aliasof":
+					aliasOf = arg
+				case "query":
+					alternates = append(alternates, altSource{formSrc, arg})
+				case "filebytes":
+					if src != noSrc {
+						panic(fmt.Errorf(`field %v.%s has conflicting modifier %q in form:%q tag`, structTyp, field.Name, mod, formTag))
+					}
+					if fieldTyp.Kind() != reflect.Slice || fieldTyp.Elem().Kind() != reflect.Uint8 || arg == "" {
+						panic(fmt.Errorf(`field %v.%s is sourced from filebytes and must be []byte, got %v`, structTyp, field.Name, fieldTyp))
+					}
+					src = fileBytesSrc
+					fileKey = arg
+				case "context":
+					if src != noSrc {
+						panic(fmt.Errorf(`field %v.%s has conflicting modifier %q in form:%q tag`, structTyp, field.Name, mod, formTag))
+					}
+					if _, found := conf.contextKeys[arg]; !found {
+						panic(fmt.Errorf(`field %v.%s has modifier "context" in form:%q tag, but context key %q is not registered via RegisterContextKey`, structTyp, field.Name, formTag, arg))
+					}
+					src = contextSrc
+					contextName = arg
+				default:
+					panic(fmt.Errorf(`field %v.%s has unknown modifier %q in form:%q tag`, structTyp, field.Name, mod, formTag))
+				}
 			}
 		}
 	}
 	if src == noSrc {
 		src = formSrc
 	}
+	if conf.TrimAllValues && src == formSrc {
+		ropt.trim = true
+	}
 
 	if src.IsNamed() && !formPresent && !jsonPresent {
 		panic(fmt.Errorf(`field %v.%s must have form:"..." or json:"..." tag; use json:"-" to skip`, structTyp, field.Name))
@@ -237,14 +1020,38 @@ func (conf *Configuration) examineField(fieldIdx int, field *reflect.StructField
 		panic(fmt.Errorf(`field %v.%s is sourced from %v and must have json:"-" tag to disallow populating it from a JSON body`, structTyp, field.Name, src))
 	}
 
+	if dir != bothDirs && src != formSrc && src != headerSrc && src != cookieSrc {
+		panic(fmt.Errorf(`field %v.%s is sourced from %v and cannot be readonly or writeonly`, structTyp, field.Name, src))
+	}
+
+	if isImmutable && (!src.IsNamed() || src == cookieStructSrc || dir == responseOnly) {
+		panic(fmt.Errorf(`field %v.%s has modifier "immutable" in form:%q tag, which requires a field decoded from form, path, header or cookie`, structTyp, field.Name, formTag))
+	}
+
 	if !src.IsNamed() {
 		if formName != "" {
 			panic(fmt.Errorf(`field %v.%s is sourced from %v and cannot have a name in form:%q tag`, structTyp, field.Name, src, formTag))
 		}
-		return &fieldMeta{
+		fm := &fieldMeta{
 			fieldIdx: fieldIdx,
 			Source:   src,
+			Optional: isOptional,
 		}
+		if src == contextSrc {
+			fm.ContextName, fm.ContextKey = contextName, conf.contextKeys[contextName]
+		}
+		if src == methodSrc && fieldTyp != stringType {
+			// a method enum, validated by its parser
+			fm.Parse = conf.pickParser(fieldTyp, ropt)
+			if fm.Parse == nil {
+				panic(fmt.Errorf(`field %v.%s is sourced from method and must be a string or a type that parses from one, got %v`, structTyp, field.Name, fieldTyp))
+			}
+		}
+		return fm
+	}
+
+	if src == formSrc && jsonSkipped && formName == "" {
+		return nil // json:"-" skips the field entirely
 	}
 
 	var name string
@@ -263,15 +1070,173 @@ func (conf *Configuration) examineField(fieldIdx int, field *reflect.StructField
 		name = formName
 	}
 
+	if src == cookieStructSrc || src == fileSrc || src == fileBytesSrc {
+		return &fieldMeta{
+			fieldIdx:  fieldIdx,
+			name:      name,
+			Source:    src,
+			Direction: dir,
+			Optional:  isOptional,
+			FileKey:   fileKey,
+		}
+	}
+
 	fm := &fieldMeta{
-		fieldIdx:   fieldIdx,
-		name:       name,
-		Parse:      pickParser(fieldTyp, ropt),
-		Stringify:  pickStringer(fieldTyp, ropt),
-		Source:     src,
-		Optional:   isOptional,
-		NotInBody:  isNotInBody,
-		IsJSONOnly: isJSONOnly,
+		fieldIdx:        fieldIdx,
+		name:            name,
+		Parse:           conf.pickParser(fieldTyp, ropt),
+		Stringify:       conf.pickStringer(fieldTyp, ropt),
+		Source:          src,
+		Direction:       dir,
+		Group:           isGroup,
+		IsFlag:          isFlag,
+		Nullable:        isNullable,
+		MaxItems:        ropt.maxItems,
+		OmitEmpty:       isOmitEmpty,
+		CaseInsensitive: ropt.caseInsensitive,
+		Optional:        isOptional,
+		NotInBody:       isNotInBody,
+		IsJSONOnly:      isJSONOnly,
+		QueryOnly:       isQueryOnly,
+		Immutable:       isImmutable,
+		Hidden:          isHidden,
+		Required:        isRequired,
+		RequiredWith:    requiredWith,
+		RequiredWithout: requiredWithout,
+	}
+	if ropt.caseInsensitive || ropt.enumNumbers {
+		baseTyp := fieldTyp
+		for baseTyp.Kind() == reflect.Pointer || baseTyp.Kind() == reflect.Slice {
+			baseTyp = baseTyp.Elem()
+		}
+		if conf.intEnums[baseTyp] == nil {
+			mod := "caseinsensitive"
+			if !ropt.caseInsensitive {
+				mod = "enumnumbers"
+			}
+			panic(fmt.Errorf(`field %v.%s has modifier %q in form:%q tag, but %v is not a registered enum`, structTyp, field.Name, mod, formTag, baseTyp))
+		}
+	}
+	if ropt.units != nil {
+		baseTyp := fieldTyp
+		for baseTyp.Kind() == reflect.Pointer || baseTyp.Kind() == reflect.Slice {
+			baseTyp = baseTyp.Elem()
+		}
+		if !isNumberKind(baseTyp.Kind()) {
+			panic(fmt.Errorf(`field %v.%s has modifier "units" in form:%q tag, which requires a numeric field`, structTyp, field.Name, formTag))
+		}
+	}
+	if ropt.decimalComma {
+		baseTyp := fieldTyp
+		for baseTyp.Kind() == reflect.Pointer || baseTyp.Kind() == reflect.Slice {
+			baseTyp = baseTyp.Elem()
+		}
+		if baseTyp.Kind() != reflect.Float32 && baseTyp.Kind() != reflect.Float64 {
+			panic(fmt.Errorf(`field %v.%s has modifier "decimalcomma" in form:%q tag, which requires a float field`, structTyp, field.Name, formTag))
+		}
+		if fieldTyp.Kind() == reflect.Slice && ropt.sep == ',' {
+			panic(fmt.Errorf(`field %v.%s has modifier "decimalcomma" in form:%q tag, which conflicts with "sep=comma"`, structTyp, field.Name, formTag))
+		}
+	}
+	if isRequired && (src != formSrc || dir == responseOnly || isOptional) {
+		panic(fmt.Errorf(`field %v.%s has modifier "required" in form:%q tag, which requires a non-optional form field`, structTyp, field.Name, formTag))
+	}
+	if (requiredWith != nil || requiredWithout != nil) && (src != formSrc || dir == responseOnly) {
+		panic(fmt.Errorf(`field %v.%s has modifier "requiredwith" or "requiredwithout" in form:%q tag, which requires a form field`, structTyp, field.Name, formTag))
+	}
+	if hasDefault {
+		if (src != formSrc && src != pathSrc && src != headerSrc && src != cookieSrc) || dir == responseOnly || isRequired || fm.Parse == nil {
+			panic(fmt.Errorf(`field %v.%s has modifier "default" in form:%q tag, which requires a non-required field decoded from form, path, header or cookie`, structTyp, field.Name, formTag))
+		}
+		if _, err := fm.Parse(defaultValue); err != nil {
+			panic(fmt.Errorf(`field %v.%s has invalid default value %q in form:%q tag: %v`, structTyp, field.Name, defaultValue, formTag, err))
+		}
+		fm.Default, fm.HasDefault = defaultValue, true
+	}
+	if defaultFrom != "" {
+		if dir == responseOnly {
+			panic(fmt.Errorf(`field %v.%s has modifier "defaultfrom" in form:%q tag, which requires a decodable field`, structTyp, field.Name, formTag))
+		}
+		fm.DefaultFrom = defaultFrom
+	}
+	if aliasOf != "" {
+		if dir == responseOnly {
+			panic(fmt.Errorf(`field %v.%s has modifier "aliasof" in form:%q tag, which requires a decodable field`, structTyp, field.Name, formTag))
+		}
+		fm.AliasOf = aliasOf
+	}
+	if isSorted {
+		if fieldTyp.Kind() != reflect.Slice || dir == responseOnly {
+			panic(fmt.Errorf(`field %v.%s has modifier "sort" in form:%q tag, which requires a slice`, structTyp, field.Name, formTag))
+		}
+		fm.Sort = true
+		if !isOrderedKind(fieldTyp.Elem().Kind()) {
+			fm.SortKey = conf.pickStringer(fieldTyp.Elem(), fieldStringRepresenationOpts{})
+			if fm.SortKey == nil {
+				panic(fmt.Errorf(`field %v.%s has modifier "sort" in form:%q tag, but %v items cannot be compared`, structTyp, field.Name, formTag, fieldTyp.Elem()))
+			}
+		}
+	}
+	if isQueryOnly && (src != formSrc || dir == responseOnly || isJSONOnly) {
+		panic(fmt.Errorf(`field %v.%s has modifier "queryonly" in form:%q tag, which requires a decodable form field`, structTyp, field.Name, formTag))
+	}
+	if alternates != nil {
+		if src != headerSrc || dir == responseOnly {
+			panic(fmt.Errorf(`field %v.%s has modifier "query" in form:%q tag, which requires a header field`, structTyp, field.Name, formTag))
+		}
+		fm.Alternates = alternates
+	}
+	if ropt.maxItems > 0 && fieldTyp.Kind() != reflect.Slice {
+		panic(fmt.Errorf(`field %v.%s has modifier "maxitems" in form:%q tag, which requires a slice`, structTyp, field.Name, formTag))
+	}
+	if isFlag && (src != formSrc || (fieldTyp.Kind() != reflect.Bool && !(fieldTyp.Kind() == reflect.Pointer && fieldTyp.Elem().Kind() == reflect.Bool))) {
+		panic(fmt.Errorf(`field %v.%s has modifier "flag" in form:%q tag, which requires a bool form field`, structTyp, field.Name, formTag))
+	}
+	if isNested {
+		nestedTyp := fieldTyp
+		if nestedTyp.Kind() == reflect.Pointer {
+			nestedTyp = nestedTyp.Elem()
+		}
+		if src != formSrc || nestedTyp.Kind() != reflect.Struct {
+			panic(fmt.Errorf(`field %v.%s has modifier "nestedform" in form:%q tag, which requires a struct or a pointer to one sourced from form`, structTyp, field.Name, formTag))
+		}
+		fm.Parse, fm.Stringify = conf.nestedFormParser(fieldTyp), conf.nestedFormStringer(fieldTyp)
+	}
+	if isNullable && (src == pathSrc || (fieldTyp.Kind() != reflect.Pointer && !isNullStruct(fieldTyp))) {
+		panic(fmt.Errorf(`field %v.%s has modifier "nullable" in form:%q tag, which requires a pointer or a sql.Null-like type sourced from form, header or cookie`, structTyp, field.Name, formTag))
+	}
+	if isGroup {
+		elemTyp := fieldTyp
+		if elemTyp.Kind() == reflect.Pointer {
+			elemTyp = elemTyp.Elem()
+		}
+		if !isIntegerKind(elemTyp.Kind()) {
+			panic(fmt.Errorf(`field %v.%s has modifier "group" in form:%q tag, but %v is not an integer type`, structTyp, field.Name, formTag, fieldTyp))
+		}
+		fm.Parse, fm.Stringify = groupedParser(fm.Parse), groupedStringer(fm.Stringify)
+	}
+	if isHeaderList {
+		if src != headerSrc || fieldTyp.Kind() != reflect.Slice || fieldTyp.Elem().Kind() != reflect.String {
+			panic(fmt.Errorf(`field %v.%s has modifier "headerlist" in form:%q tag, which requires a []string header field`, structTyp, field.Name, formTag))
+		}
+		fm.Parse, fm.Stringify = headerListParser(fieldTyp), headerListStringer
+		fm.HeaderList = true
+	}
+	if jsonQuoted && src == formSrc && fm.Parse != nil && isJSONQuotable(fieldTyp) {
+		fm.Parse = quotedParser(fm.Parse)
+	}
+	if (src == cookieSrc || src == formSrc) && fieldTyp.Kind() == reflect.Slice && fieldTyp.Elem().Kind() != reflect.Uint8 {
+		fm.ParseItem = conf.pickParser(fieldTyp.Elem(), ropt)
+	}
+	if src == formSrc && fieldTyp.Kind() == reflect.Map && fieldTyp.Key().Kind() == reflect.String && fm.Parse == nil && !isJSONOnly {
+		fm.ParseEntry, fm.StringifyEntry = conf.pickParser(fieldTyp.Elem(), ropt), conf.pickStringer(fieldTyp.Elem(), ropt)
+		if fm.ParseEntry == nil || fm.StringifyEntry == nil {
+			panic(fmt.Errorf("field %v.%v: don't know how to convert map values of type %v to and from strings", structTyp, field.Name, fieldTyp.Elem()))
+		}
+		return fm
+	}
+	if k := fieldTyp.Kind(); (k == reflect.Chan || k == reflect.Func || k == reflect.UnsafePointer) && !isJSONOnly {
+		panic(fmt.Errorf(`field %v.%s is a %v, which cannot be decoded; use json:"-" to skip it`, structTyp, field.Name, k))
 	}
 	if fm.Parse == nil && !isJSONOnly {
 		panic(fmt.Errorf("field %v.%v: don't know how to parse %v from a string", structTyp, field.Name, fieldTyp))
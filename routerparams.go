@@ -2,13 +2,25 @@ package httpform
 
 import (
 	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
 )
 
+// interpretPathParams adapts the pathParams argument of Decode, which can be
+// nil, bunrouter.Params, a map[string]string (e.g. from mux.Vars), url.Values
+// or a lookup function like func(key string) (string, bool).
 func interpretPathParams(pathParams any) pathParamsImpl {
 	if pathParams == nil {
 		return noPathParamsImpl{}
 	} else if v, ok := pathParams.(bunRouterParams); ok {
 		return &bunRouterParamsImpl{v}
+	} else if v, ok := pathParams.(map[string]string); ok {
+		return mapPathParamsImpl(v)
+	} else if v, ok := pathParams.(url.Values); ok {
+		return valuesPathParamsImpl(v)
+	} else if v, ok := pathParams.(func(key string) (string, bool)); ok {
+		return funcPathParamsImpl(v)
 	} else {
 		panic(fmt.Errorf("unsupported pathParams %T", pathParams))
 	}
@@ -16,7 +28,7 @@ func interpretPathParams(pathParams any) pathParamsImpl {
 
 type pathParamsImpl interface {
 	Get(key string) string
-	Keys() []string
+	Keys() ([]string, bool) // false if the router cannot list them
 }
 
 type noPathParamsImpl struct{}
@@ -25,8 +37,71 @@ func (_ noPathParamsImpl) Get(key string) string {
 	return ""
 }
 
-func (_ noPathParamsImpl) Keys() []string {
-	return nil
+func (_ noPathParamsImpl) Keys() ([]string, bool) {
+	return nil, true
+}
+
+type mapPathParamsImpl map[string]string
+
+func (impl mapPathParamsImpl) Get(key string) string {
+	return impl[key]
+}
+
+func (impl mapPathParamsImpl) Keys() ([]string, bool) {
+	result := make([]string, 0, len(impl))
+	for k := range impl {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+	return result, true
+}
+
+type valuesPathParamsImpl url.Values
+
+func (impl valuesPathParamsImpl) Get(key string) string {
+	return url.Values(impl).Get(key)
+}
+
+func (impl valuesPathParamsImpl) Keys() ([]string, bool) {
+	result := make([]string, 0, len(impl))
+	for k := range impl {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+	return result, true
+}
+
+type funcPathParamsImpl func(key string) (string, bool)
+
+func (impl funcPathParamsImpl) Get(key string) string {
+	v, _ := impl(key)
+	return v
+}
+
+func (impl funcPathParamsImpl) Keys() ([]string, bool) {
+	return nil, false
+}
+
+// serveMuxPathParamsImpl reads wildcards matched by http.ServeMux patterns
+// like /items/{id} (Go 1.22+) via r.PathValue, see UseServeMuxPathValues.
+// The method is looked up dynamically to keep building with older Go.
+type serveMuxPathParamsImpl struct {
+	r *http.Request
+}
+
+type pathValuer interface {
+	PathValue(name string) string
+}
+
+func (impl serveMuxPathParamsImpl) Get(key string) string {
+	if pv, ok := any(impl.r).(pathValuer); ok {
+		return pv.PathValue(key)
+	}
+	return ""
+}
+
+func (impl serveMuxPathParamsImpl) Keys() ([]string, bool) {
+	return nil, false
 }
 
 type bunRouterParamsImpl struct {
@@ -38,13 +113,13 @@ func (impl bunRouterParamsImpl) Get(key string) string {
 	return v
 }
 
-func (impl bunRouterParamsImpl) Keys() []string {
+func (impl bunRouterParamsImpl) Keys() ([]string, bool) {
 	m := impl.params.Map()
 	result := make([]string, 0, len(m))
 	for k := range m {
 		result = append(result, k)
 	}
-	return result
+	return result, true
 }
 
 type bunRouterParams interface {
@@ -1,15 +1,27 @@
 package httpform
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"mime"
+	"mime/multipart"
+	"net"
 	"net/http"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
 )
 
 const (
 	formContentType          = "application/x-www-form-urlencoded"
 	multipartFormContentType = "multipart/form-data"
 	jsonContentType          = "application/json"
+	gobContentType           = "application/gob"
 )
 
 func determineMIMEType(r *http.Request) string {
@@ -24,6 +36,108 @@ func determineMIMEType(r *http.Request) string {
 	return ctype
 }
 
+// bareQueryKeys returns the keys that appear without “=” in the query string,
+// like “active” in “?active&page=2”, which url.ParseQuery cannot distinguish
+// from “?active=”.
+func bareQueryKeys(rawQuery string) map[string]bool {
+	var result map[string]bool
+	for rawQuery != "" {
+		var item string
+		item, rawQuery, _ = strings.Cut(rawQuery, "&")
+		if item == "" || strings.Contains(item, "=") {
+			continue
+		}
+		key, err := url.QueryUnescape(item)
+		if err != nil {
+			continue
+		}
+		if result == nil {
+			result = make(map[string]bool)
+		}
+		result[key] = true
+	}
+	return result
+}
+
+// foldFormKeys renames params that match form fields case-insensitively to
+// the exact field names, for Configuration.CaseInsensitiveFields. Values of
+// exactly matching params come last, so that they win.
+func foldFormKeys(form url.Values, sm *structMeta) url.Values {
+	result := make(url.Values, len(form))
+	for k, vv := range form {
+		if sm.NamedFields[k] != nil {
+			continue
+		}
+		if fm := sm.FoldedFields[strings.ToLower(k)]; fm != nil {
+			k = fm.name
+		}
+		result[k] = append(result[k], vv...)
+	}
+	for k, vv := range form {
+		if sm.NamedFields[k] != nil {
+			result[k] = append(result[k], vv...)
+		}
+	}
+	return result
+}
+
+// unwrapJSONEnvelope returns the value of the given key of a JSON object.
+// An empty body stays empty, so that callers handle io.EOF uniformly.
+func (conf *Configuration) unwrapJSONEnvelope(body io.Reader, key string) (io.Reader, error) {
+	var wrapper map[string]json.RawMessage
+	err := conf.newJSONDecoder(body).Decode(&wrapper)
+	if err == io.EOF {
+		return strings.NewReader(""), nil
+	} else if err != nil {
+		return nil, err
+	}
+	inner, found := wrapper[key]
+	if !found {
+		return nil, fmt.Errorf("missing %q envelope", key)
+	}
+	return bytes.NewReader(inner), nil
+}
+
+func groupCookies(r *http.Request) map[string][]*http.Cookie {
+	cookies := make(map[string][]*http.Cookie)
+	for _, cookie := range r.Cookies() {
+		cookies[cookie.Name] = append(cookies[cookie.Name], cookie)
+	}
+	return cookies
+}
+
+const defaultMaxFileBytes = 1 * MB
+
+var errFileTooLarge = errors.New("file too large")
+
+// readFileBytes reads an uploaded file, failing with errFileTooLarge instead
+// of reading more than maxSize bytes.
+func readFileBytes(fh *multipart.FileHeader, maxSize int64) ([]byte, error) {
+	if fh.Size > maxSize {
+		return nil, errFileTooLarge
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, errFileTooLarge
+	}
+	return data, nil
+}
+
+// looksLikeJSON reports whether s is a JSON object or array, judging by
+// its first non-space character.
+func looksLikeJSON(s string) bool {
+	s = strings.TrimLeft(s, " \t\r\n")
+	return s != "" && (s[0] == '{' || s[0] == '[')
+}
+
 func LimitBody(w http.ResponseWriter, r *http.Request, maxSize int64) {
 	if r.Body != nil {
 		r.Body = http.MaxBytesReader(w, r.Body, maxSize)
@@ -50,16 +164,126 @@ func parseBoolDefault(str string, dflt bool) bool {
 	}
 }
 
-func filterInPlace[S ~[]T, T any](slice S, filter func(item T) (T, bool)) S {
-	o := 0
-	for i, item := range slice {
-		item, add := filter(item)
-		if add {
-			if o != i {
-				slice[o] = item
+func indexOf[T comparable](slice []T, item T) int {
+	for i, v := range slice {
+		if v == item {
+			return i
+		}
+	}
+	return -1
+}
+
+// mergeMaps returns a new map with the entries of a, overridden by those of
+// b. Configuration registries are copy on write, so that clones don't share
+// registrations.
+//
+// M is any map type rather than map[K]V: until Go 1.20, reflect.Type keys
+// don't satisfy comparable.
+func mergeMaps[M any](a, b M) M {
+	av, bv := reflect.ValueOf(a), reflect.ValueOf(b)
+	m := reflect.MakeMapWithSize(av.Type(), av.Len()+bv.Len())
+	for _, src := range []reflect.Value{av, bv} {
+		for iter := src.MapRange(); iter.Next(); {
+			m.SetMapIndex(iter.Key(), iter.Value())
+		}
+	}
+	return m.Interface().(M)
+}
+
+// contextReader fails reads once ctx is done.
+type contextReader struct {
+	ctx context.Context
+	io.ReadCloser
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.ReadCloser.Read(p)
+}
+
+func isBoolType(typ reflect.Type) bool {
+	if typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	return typ.Kind() == reflect.Bool
+}
+
+// coerceJSONBools rewrites 0 and 1 as false and true in the given top-level
+// fields of a JSON object. Malformed JSON is returned as is for the decoder
+// to report.
+func (conf *Configuration) coerceJSONBools(data []byte, fields []*fieldMeta) ([]byte, error) {
+	var obj map[string]json.RawMessage
+	if conf.unmarshalJSON(data, &obj) != nil || obj == nil {
+		return data, nil
+	}
+	changed := false
+	for _, fm := range fields {
+		raw, found := obj[fm.name]
+		if !found {
+			continue
+		}
+		switch v := string(bytes.TrimSpace(raw)); {
+		case v == "0":
+			obj[fm.name], changed = json.RawMessage("false"), true
+		case v == "1":
+			obj[fm.name], changed = json.RawMessage("true"), true
+		case v != "" && (v[0] == '-' || (v[0] >= '0' && v[0] <= '9')):
+			return nil, fmt.Errorf("field %q: expected boolean, got number %s", fm.name, v)
+		}
+	}
+	if !changed {
+		return data, nil
+	}
+	return json.Marshal(obj)
+}
+
+// isJSONValuePresent treats a missing key and an explicit null alike.
+func isJSONValuePresent(raw json.RawMessage) bool {
+	v := bytes.TrimSpace(raw)
+	return len(v) > 0 && string(v) != "null"
+}
+
+// dispositionFilename returns the filename parameter of a Content-Disposition
+// header, stripped of any directories like multipart.FileHeader.Filename.
+func dispositionFilename(header string) (string, error) {
+	if header == "" {
+		return "", nil
+	}
+	_, params, err := mime.ParseMediaType(header)
+	if err != nil {
+		return "", err
+	}
+	filename := params["filename"]
+	if filename == "" {
+		return "", nil
+	}
+	return filepath.Base(filename), nil
+}
+
+// remoteIP returns the client IP without the port, preferring the rightmost
+// X-Forwarded-For address, then X-Real-IP, when trustForwarded is set.
+// Proxies append to X-Forwarded-For, so only the rightmost address, added
+// by the proxy in front of us, can't come from the client.
+func remoteIP(r *http.Request, trustForwarded bool) string {
+	if trustForwarded {
+		if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+			last := xff[len(xff)-1]
+			if i := strings.LastIndexByte(last, ','); i >= 0 {
+				last = last[i+1:]
 			}
-			o++
+			if ip := strings.TrimSpace(last); ip != "" {
+				return ip
+			}
+		}
+		if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+			return ip
 		}
 	}
-	return slice[:o]
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr // no port
+	}
+	return host
 }
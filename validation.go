@@ -0,0 +1,107 @@
+package httpform
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// StructValidator validates a decoded struct, e.g. according to its
+// validate:"..." tags. *validator.Validate from go-playground/validator
+// satisfies it as is, so httpform doesn't need to depend on it.
+//
+// Struct must report invalid fields as a slice of errors with Field,
+// StructField and Tag methods, like go-playground/validator does. Other
+// errors, e.g. its InvalidValidationError, mean a programming error and
+// fail the request with a 500.
+type StructValidator interface {
+	Struct(s any) error
+}
+
+// AfterDecoder is implemented by structs that want to normalize or
+// cross-check their fields, e.g. that start < end, once decoding has set
+// them all and validation has passed. A returned *Error keeps its code;
+// other errors become 400s.
+type AfterDecoder interface {
+	AfterDecode(r *http.Request) error
+}
+
+func afterDecode(r *http.Request, destValPtr reflect.Value) error {
+	hook, ok := destValPtr.Interface().(AfterDecoder)
+	if !ok {
+		return nil
+	}
+	err := hook.AfterDecode(r)
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	return &Error{http.StatusBadRequest, "", err}
+}
+
+// FieldError is a single field that failed validation.
+type FieldError struct {
+	Field string // form name when known, otherwise as reported by the validator
+	Tag   string // failed rule, e.g. "required" or "email"
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: failed %q validation", e.Field, e.Tag)
+}
+
+// ValidationErrors lists all fields that failed validation.
+type ValidationErrors []FieldError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// validatorFieldError matches go-playground/validator's FieldError.
+type validatorFieldError interface {
+	Field() string
+	StructField() string
+	Tag() string
+}
+
+func (conf *Configuration) validate(destValPtr reflect.Value, sm *structMeta) error {
+	if conf.Validator == nil {
+		return &Error{http.StatusInternalServerError, "UseValidatorTags requires Validator", nil}
+	}
+	err := conf.Validator.Struct(destValPtr.Interface())
+	if err == nil {
+		return nil
+	}
+
+	// go-playground/validator returns a slice of its own FieldError interface
+	errVal := reflect.ValueOf(err)
+	if errVal.Kind() != reflect.Slice {
+		return &Error{http.StatusInternalServerError, "validator", err}
+	}
+	structTyp := destValPtr.Elem().Type()
+	verrs := make(ValidationErrors, 0, errVal.Len())
+	for i, n := 0, errVal.Len(); i < n; i++ {
+		fe, ok := errVal.Index(i).Interface().(validatorFieldError)
+		if !ok {
+			return &Error{http.StatusInternalServerError, "validator", err}
+		}
+		verrs = append(verrs, FieldError{formFieldName(structTyp, sm, fe.StructField(), fe.Field()), fe.Tag()})
+	}
+	return &Error{http.StatusBadRequest, "", verrs}
+}
+
+// formFieldName maps a Go field name to its form name, if it's a top-level field.
+func formFieldName(structTyp reflect.Type, sm *structMeta, goName, fallback string) string {
+	for _, fm := range sm.OrderedFields {
+		if structTyp.Field(fm.fieldIdx).Name == goName {
+			return fm.name
+		}
+	}
+	return fallback
+}